@@ -2,10 +2,11 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
@@ -18,7 +19,9 @@ import (
 
 	"github.com/MrPark97/tages/pb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -57,6 +60,18 @@ func uploadImage(imageClient pb.ImageServiceClient, imageName string, imagePath
 	}
 	defer file.Close()
 
+	// trying to compute image checksum so the server can verify received data
+	checksum, err := fileChecksum(file)
+	if err != nil {
+		log.Fatal("cannot compute image checksum: ", err)
+	}
+
+	// trying to get image modification time
+	fileInfo, err := file.Stat()
+	if err != nil {
+		log.Fatal("cannot stat image file: ", err)
+	}
+
 	// setting up 5 seconds timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -71,9 +86,10 @@ func uploadImage(imageClient pb.ImageServiceClient, imageName string, imagePath
 	req := &pb.UploadImageRequest{
 		Data: &pb.UploadImageRequest_Info{
 			Info: &pb.Info{
-				Name:      imageName,
-				Type:      filepath.Ext(imagePath),
-				UpdatedAt: timestamppb.Now(),
+				Name:             imageName,
+				Type:             filepath.Ext(imagePath),
+				SourceModifiedAt: timestamppb.New(fileInfo.ModTime()),
+				Checksum:         checksum,
 			},
 		},
 	}
@@ -118,33 +134,244 @@ func uploadImage(imageClient pb.ImageServiceClient, imageName string, imagePath
 		log.Fatal("cannot receive response: ", err)
 	}
 
-	log.Printf("image uploaded with name: %s, size: %d", res.GetName(), res.GetSize())
+	log.Printf("image uploaded with name: %s, size: %d, checksum: %s", res.GetName(), res.GetSize(), res.GetChecksum())
+}
+
+// number of attempts to append data to an upload session before giving up
+const maxUploadAttempts = 5
+
+// function to upload image via upload session, continuing from the committed offset after failures
+func uploadImageResumable(imageClient pb.ImageServiceClient, imageName string, imagePath string) {
+	// trying to open image
+	file, err := os.Open(imagePath)
+	if err != nil {
+		log.Fatal("cannot open image file: ", err)
+	}
+	defer file.Close()
+
+	// trying to compute image checksum so the server can verify data of all attempts
+	checksum, err := fileChecksum(file)
+	if err != nil {
+		log.Fatal("cannot compute image checksum: ", err)
+	}
+
+	// trying to get image size and modification time
+	fileInfo, err := file.Stat()
+	if err != nil {
+		log.Fatal("cannot stat image file: ", err)
+	}
+
+	// trying to create upload session
+	sessionID, err := createUploadSession(imageClient, &pb.Info{
+		Name:             imageName,
+		Type:             filepath.Ext(imagePath),
+		SourceModifiedAt: timestamppb.New(fileInfo.ModTime()),
+		Checksum:         checksum,
+	})
+	if err != nil {
+		log.Fatal("cannot create upload session: ", err)
+	}
+	log.Printf("created upload session %s", sessionID)
+
+	// append data until everything is committed
+	offset := int64(0)
+	for attempt := 1; offset < fileInfo.Size(); attempt++ {
+		if attempt > maxUploadAttempts {
+			log.Fatalf("cannot upload image in %d attempts", maxUploadAttempts)
+		}
+
+		offset, err = appendUploadSession(imageClient, sessionID, file, offset)
+		if err == nil {
+			continue
+		}
+		log.Printf("attempt %d to append data failed: %v", attempt, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+
+		// trying to get committed offset to continue from
+		offset, err = getCommittedOffset(imageClient, sessionID)
+		if err != nil {
+			log.Fatal("cannot get upload session: ", err)
+		}
+	}
+
+	// trying to finalize upload session
+	res, err := finalizeUploadSession(imageClient, sessionID)
+	if err != nil {
+		log.Fatal("cannot finalize upload session: ", err)
+	}
+
+	log.Printf("image uploaded with name: %s, size: %d, checksum: %s", res.GetName(), res.GetSize(), res.GetChecksum())
+}
+
+// function to create upload session of the image, returns session identifier
+func createUploadSession(imageClient pb.ImageServiceClient, info *pb.Info) (string, error) {
+	// setting up 5 seconds timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := imageClient.CreateUploadSession(ctx, &pb.CreateUploadSessionRequest{Info: info})
+	if err != nil {
+		return "", err
+	}
+
+	return res.GetSession().GetId(), nil
+}
+
+// function to get committed offset of the upload session
+func getCommittedOffset(imageClient pb.ImageServiceClient, sessionID string) (int64, error) {
+	// setting up 5 seconds timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := imageClient.GetUploadSession(ctx, &pb.GetUploadSessionRequest{SessionId: sessionID})
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(res.GetSession().GetCommittedOffset()), nil
+}
+
+// function to finalize upload session saving its data as the image
+func finalizeUploadSession(imageClient pb.ImageServiceClient, sessionID string) (*pb.UploadImageResponse, error) {
+	// setting up 5 seconds timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return imageClient.FinalizeUploadSession(ctx, &pb.FinalizeUploadSessionRequest{SessionId: sessionID})
+}
+
+// function to append the rest of the file starting from the offset to the upload session, returns committed offset
+func appendUploadSession(imageClient pb.ImageServiceClient, sessionID string, file *os.File, offset int64) (int64, error) {
+	// setting up 30 seconds timeout for a single attempt
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// trying to seek to the offset
+	_, err := file.Seek(offset, io.SeekStart)
+	if err != nil {
+		log.Fatal("cannot seek image file: ", err)
+	}
+
+	// trying to call AppendUploadSession method
+	stream, err := imageClient.AppendUploadSession(ctx)
+	if err != nil {
+		return offset, err
+	}
+
+	// init reader and buffer
+	reader := bufio.NewReader(file)
+	buffer := make([]byte, 64*1024)
+
+	chunkOffset := offset
+	for {
+		// trying to read n bytest to buffer
+		n, err := reader.Read(buffer)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal("cannot read chunk to buffer: ", err)
+		}
+
+		// trying to send request
+		err = stream.Send(&pb.AppendUploadSessionRequest{
+			SessionId: sessionID,
+			Offset:    uint64(chunkOffset),
+			ChunkData: buffer[:n],
+		})
+		if err != nil {
+			return offset, fmt.Errorf("cannot send chunk to server: %v: %v", err, stream.RecvMsg(nil))
+		}
+		chunkOffset += int64(n)
+	}
+
+	// trying to get response
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return offset, err
+	}
+
+	return int64(res.GetSession().GetCommittedOffset()), nil
+}
+
+// function to compute hex encoded SHA-256 of the file content (file offset is restored to the beginning)
+func fileChecksum(file *os.File) (string, error) {
+	hash := sha256.New()
+	_, err := io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
+// function to download image to the path, resuming partial file (with ".part" suffix) left by an interrupted download
 func downloadImage(imageClient pb.ImageServiceClient, imageName string, imagePath string) {
+	partPath := imagePath + ".part"
+	checksumPath := partPath + ".checksum"
+
 	// setting up 5 seconds timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// prepare download image request
+	// prepare download image request continuing partial file of the same image (if there is one)
 	req := &pb.DownloadImageRequest{Name: imageName}
+	partInfo, partErr := os.Stat(partPath)
+	partChecksum, checksumErr := os.ReadFile(checksumPath)
+	if partErr == nil && checksumErr == nil {
+		req.Offset = uint64(partInfo.Size())
+		req.ExpectedChecksum = string(partChecksum)
+		log.Printf("resuming download of image %s from offset %d", imageName, req.Offset)
+	} else if file, err := os.Open(imagePath); err == nil {
+		// ask to skip the download if the image already downloaded to the path is current
+		req.IfNoneMatch, err = fileChecksum(file)
+		file.Close()
+		if err != nil {
+			log.Fatal("cannot compute image checksum: ", err)
+		}
+	}
 
-	// trying to call DownloadImage method
-	stream, err := imageClient.DownloadImage(ctx, req)
+	// trying to call DownloadImage method and get image info
+	stream, res, err := startDownload(ctx, imageClient, req)
+	if (status.Code(err) == codes.FailedPrecondition || status.Code(err) == codes.OutOfRange) && req.Offset > 0 {
+		// the image was changed since the partial file was downloaded, start over
+		log.Printf("cannot resume download: %v, starting over", err)
+		req.Offset = 0
+		req.ExpectedChecksum = ""
+		stream, res, err = startDownload(ctx, imageClient, req)
+	}
 	if err != nil {
 		log.Fatal("cannot download image: ", err)
 	}
+	info := res.GetInfo()
+	if info.GetNotModified() {
+		log.Printf("image %s is not modified since downloaded to %s", imageName, imagePath)
+		return
+	}
+	log.Printf("received image info - name: %s, type: %s, updated_at: %s, size: %d, resolution: %dx%d, format: %s, color model: %s", info.GetName(), info.GetType(), info.GetUpdatedAt().AsTime(), info.GetSize(), info.GetWidth(), info.GetHeight(), info.GetFormat(), info.GetColorModel())
 
-	// trying to get image info
-	res, err := stream.Recv()
+	// trying to open partial file (truncating it if the download starts over) and remember checksum of the image
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if req.Offset == 0 {
+		flags |= os.O_TRUNC
+		err = os.WriteFile(checksumPath, []byte(info.GetChecksum()), 0644)
+		if err != nil {
+			log.Fatal("cannot write checksum file: ", err)
+		}
+	}
+	file, err := os.OpenFile(partPath, flags, 0644)
 	if err != nil {
-		log.Fatal("cannot receive response: ", err)
+		log.Fatal("cannot open partial image file: ", err)
 	}
-	log.Printf("received image info - name: %s, type: %s, updated_at: %s", res.GetInfo().GetName(), res.GetInfo().GetType(), res.GetInfo().GetUpdatedAt().AsTime())
+	defer file.Close()
 
-	// init variables for bytes of image and image size
-	imageData := bytes.Buffer{}
-	imageSize := 0
+	// init variable for image size
+	imageSize := int(req.Offset)
 
 	for {
 		res, err := stream.Recv()
@@ -152,7 +379,7 @@ func downloadImage(imageClient pb.ImageServiceClient, imageName string, imagePat
 			break
 		}
 		if err != nil {
-			log.Fatal("cannot receive response: ", err)
+			log.Fatal("cannot receive response (run again to resume the download): ", err)
 		}
 
 		// getting chunk of data and calculating size
@@ -162,32 +389,66 @@ func downloadImage(imageClient pb.ImageServiceClient, imageName string, imagePat
 		// counting image size
 		imageSize += size
 
-		// trying to write data to buffer
-		_, err = imageData.Write(chunk)
+		// trying to write data to partial file
+		_, err = file.Write(chunk)
 		if err != nil {
 			log.Fatal("cannot write chunk of data: ", err)
 		}
 	}
 
-	// trying to create file
-	file, err := os.Create(imagePath)
+	// trying to close partial file
+	err = file.Close()
 	if err != nil {
-		log.Fatal("cannot create image file: ", err)
+		log.Fatal("cannot write image to file: ", err)
 	}
 
-	// trying to write data to file
-	_, err = imageData.WriteTo(file)
+	// check that received data matches checksum of the stored image, drop the partial file otherwise
+	file, err = os.Open(partPath)
 	if err != nil {
-		log.Fatal("cannot write image to file: ", err)
+		log.Fatal("cannot open partial image file: ", err)
+	}
+	checksum, err := fileChecksum(file)
+	file.Close()
+	if err != nil {
+		log.Fatal("cannot compute image checksum: ", err)
+	}
+	if checksum != info.GetChecksum() {
+		os.Remove(partPath)
+		os.Remove(checksumPath)
+		log.Fatalf("image checksum mismatch: expected %s, received data has %s", info.GetChecksum(), checksum)
+	}
+
+	// trying to move complete file to the image path
+	err = os.Rename(partPath, imagePath)
+	if err != nil {
+		log.Fatal("cannot move image file: ", err)
 	}
+	os.Remove(checksumPath)
 
 	log.Printf("image downloaded with name: %s, size: %d", imageName, imageSize)
 }
 
-func getUploadedImagesTableString(imageClient pb.ImageServiceClient, limit uint32) {
+// function to call DownloadImage method and receive image info (the first error of the stream is returned)
+func startDownload(ctx context.Context, imageClient pb.ImageServiceClient, req *pb.DownloadImageRequest) (pb.ImageService_DownloadImageClient, *pb.DownloadImageResponse, error) {
+	stream, err := imageClient.DownloadImage(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := stream.Recv()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return stream, res, nil
+}
+
+func getUploadedImagesTableString(imageClient pb.ImageServiceClient, limit uint32, sortField pb.SortField, sortOrder pb.SortOrder) {
 	// forming request
 	req := &pb.GetUploadedImagesTableStringRequest{
-		Limit: limit,
+		Limit:     limit,
+		SortField: sortField,
+		SortOrder: sortOrder,
 	}
 
 	// set 5s timeout context
@@ -205,11 +466,135 @@ func getUploadedImagesTableString(imageClient pb.ImageServiceClient, limit uint3
 	fmt.Print(res.GetTable())
 }
 
+func getImageInfo(imageClient pb.ImageServiceClient, imageName string) *pb.Info {
+	// set 5s timeout context
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// trying to send request
+	res, err := imageClient.GetImageInfo(ctx, &pb.GetImageInfoRequest{Name: imageName})
+	if err != nil {
+		log.Fatal("cannot get image info: ", err)
+	}
+
+	info := res.GetInfo()
+	log.Printf("image info - name: %s, type: %s, size: %d, created_at: %s, updated_at: %s", info.GetName(), info.GetType(), info.GetSize(), info.GetCreatedAt().AsTime(), info.GetUpdatedAt().AsTime())
+
+	return info
+}
+
+func listImageVersions(imageClient pb.ImageServiceClient, imageName string) {
+	// set 5s timeout context
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// trying to send request
+	res, err := imageClient.ListImageVersions(ctx, &pb.ListImageVersionsRequest{Name: imageName})
+	if err != nil {
+		log.Fatal("cannot list image versions: ", err)
+	}
+
+	for _, info := range res.GetVersions() {
+		log.Printf("image version - name: %s, version: %d, type: %s, size: %d, updated_at: %s, checksum: %s", info.GetName(), info.GetVersion(), info.GetType(), info.GetSize(), info.GetUpdatedAt().AsTime(), info.GetChecksum())
+	}
+}
+
+func restoreImageVersion(imageClient pb.ImageServiceClient, imageName string, version uint64) {
+	// set 5s timeout context
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// trying to send request
+	res, err := imageClient.RestoreImageVersion(ctx, &pb.RestoreImageVersionRequest{Name: imageName, Version: version})
+	if err != nil {
+		log.Fatal("cannot restore image version: ", err)
+	}
+
+	log.Printf("version %d of image %s restored as version %d", version, imageName, res.GetInfo().GetVersion())
+}
+
+func deleteImage(imageClient pb.ImageServiceClient, imageName string) {
+	// set 5s timeout context
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// trying to send request
+	res, err := imageClient.DeleteImage(ctx, &pb.DeleteImageRequest{Name: imageName})
+	if err != nil {
+		log.Fatal("cannot delete image: ", err)
+	}
+
+	log.Printf("image moved to the trash with name: %s", res.GetName())
+}
+
+func listTrash(imageClient pb.ImageServiceClient) []*pb.TrashItem {
+	// set 5s timeout context
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// trying to send request
+	res, err := imageClient.ListTrash(ctx, &pb.ListTrashRequest{})
+	if err != nil {
+		log.Fatal("cannot list trash: ", err)
+	}
+
+	for _, item := range res.GetItems() {
+		info := item.GetInfo()
+		log.Printf("trash item - id: %s, name: %s, type: %s, size: %d, deleted_at: %s", item.GetId(), info.GetName(), info.GetType(), info.GetSize(), item.GetDeletedAt().AsTime())
+	}
+
+	return res.GetItems()
+}
+
+func restoreImage(imageClient pb.ImageServiceClient, trashID string) {
+	// set 5s timeout context
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// trying to send request
+	res, err := imageClient.RestoreImage(ctx, &pb.RestoreImageRequest{Id: trashID})
+	if err != nil {
+		log.Fatal("cannot restore image: ", err)
+	}
+
+	log.Printf("image restored from the trash with name: %s", res.GetInfo().GetName())
+}
+
+func listImages(imageClient pb.ImageServiceClient, req *pb.ListImagesRequest) {
+	// set 5s timeout context
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for {
+		// trying to get the next page
+		res, err := imageClient.ListImages(ctx, req)
+		if err != nil {
+			log.Fatal("cannot list images: ", err)
+		}
+
+		for _, info := range res.GetImages() {
+			log.Printf("image - name: %s, type: %s, size: %d, resolution: %dx%d, format: %s, created_at: %s, updated_at: %s", info.GetName(), info.GetType(), info.GetSize(), info.GetWidth(), info.GetHeight(), info.GetFormat(), info.GetCreatedAt().AsTime(), info.GetUpdatedAt().AsTime())
+		}
+
+		// stop if there are no more pages
+		if res.GetNextPageToken() == "" {
+			log.Printf("listed %d images", res.GetTotalSize())
+			return
+		}
+		req.PageToken = res.GetNextPageToken()
+	}
+}
+
 // function to upload test image
 func testUploadImage(imageClient pb.ImageServiceClient) {
 	uploadImage(imageClient, "laptop", "tmp/laptop.jpeg")
 }
 
+// function to upload test image via upload session
+func testUploadImageResumable(imageClient pb.ImageServiceClient) {
+	uploadImageResumable(imageClient, "laptop", "tmp/laptop.jpeg")
+}
+
 // function to download test image
 func testDownloadImage(imageClient pb.ImageServiceClient) {
 	uploadImage(imageClient, "laptop", "tmp/laptop.jpeg")
@@ -223,7 +608,46 @@ func testGetUploadedImagesTableString(imageClient pb.ImageServiceClient) {
 	uploadImage(imageClient, "macbook", "tmp/macbook.png")
 	time.Sleep(2 * time.Second)
 	uploadImage(imageClient, "laptop", "tmp/laptop.jpeg")
-	getUploadedImagesTableString(imageClient, 0)
+	getUploadedImagesTableString(imageClient, 0, pb.SortField_SORT_FIELD_UPDATED_AT, pb.SortOrder_SORT_ORDER_DESC)
+}
+
+// function to get info of test image
+func testGetImageInfo(imageClient pb.ImageServiceClient) {
+	uploadImage(imageClient, "laptop", "tmp/laptop.jpeg")
+	getImageInfo(imageClient, "laptop")
+}
+
+// function to restore the first version of test image
+func testRestoreImageVersion(imageClient pb.ImageServiceClient) {
+	uploadImage(imageClient, "laptop", "tmp/laptop.jpeg")
+	uploadImage(imageClient, "laptop", "tmp/macbook.png")
+	listImageVersions(imageClient, "laptop")
+	restoreImageVersion(imageClient, "laptop", 1)
+}
+
+// function to delete test image
+func testDeleteImage(imageClient pb.ImageServiceClient) {
+	uploadImage(imageClient, "laptop", "tmp/laptop.jpeg")
+	deleteImage(imageClient, "laptop")
+}
+
+// function to delete test image and restore it from the trash
+func testRestoreImage(imageClient pb.ImageServiceClient) {
+	uploadImage(imageClient, "laptop", "tmp/laptop.jpeg")
+	deleteImage(imageClient, "laptop")
+	items := listTrash(imageClient)
+	restoreImage(imageClient, items[0].GetId())
+}
+
+// function to list uploaded images from the most recently updated
+func testListImages(imageClient pb.ImageServiceClient) {
+	uploadImage(imageClient, "laptop", "tmp/laptop.jpeg")
+	uploadImage(imageClient, "macbook", "tmp/macbook.png")
+	listImages(imageClient, &pb.ListImagesRequest{
+		PageSize:  1,
+		SortField: pb.SortField_SORT_FIELD_UPDATED_AT,
+		SortOrder: pb.SortOrder_SORT_ORDER_DESC,
+	})
 }
 
 func main() {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
@@ -9,6 +10,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
+	"time"
 
 	"github.com/MrPark97/tages/pb"
 	"github.com/MrPark97/tages/service"
@@ -44,12 +46,63 @@ func loadTLSCredentials() (credentials.TransportCredentials, error) {
 	return credentials.NewTLS(config), nil
 }
 
+func newImageStore(storeKind string, versionRetention int, memoryBudget int64) (service.ImageStore, error) {
+	switch storeKind {
+	case "memory":
+		log.Printf("keeping images in memory within %d bytes", memoryBudget)
+		return service.NewMemoryImageStore(
+			service.WithMemoryBudget(memoryBudget),
+			service.WithMemoryVersionRetention(versionRetention),
+		), nil
+	case "disk":
+		return newDiskImageStore(versionRetention)
+	default:
+		return nil, fmt.Errorf("unknown store %q", storeKind)
+	}
+}
+
+func newDiskImageStore(versionRetention int) (service.ImageStore, error) {
+	imageStore, err := service.NewDiskImageStore("img", service.WithVersionRetention(versionRetention))
+	if err != nil {
+		return nil, err
+	}
+
+	// report files of the image folder not matching the catalog
+	report, err := imageStore.CheckConsistency()
+	if err != nil {
+		log.Print("cannot check image folder consistency: ", err)
+	} else {
+		for _, path := range report.UnreferencedFiles {
+			log.Printf("file %s isn't referenced by any image", path)
+		}
+		for _, path := range report.MissingFiles {
+			log.Printf("file %s of the catalog is missing", path)
+		}
+	}
+
+	return imageStore, nil
+}
+
 func main() {
 	port := flag.Int("port", 8080, "the server port")
+	versionRetention := flag.Int("version-retention", 5, "number of previous versions kept for every image")
+	uploadSessionTTL := flag.Duration("upload-session-ttl", 24*time.Hour, "time after which idle upload sessions are removed")
+	trashRetention := flag.Duration("trash-retention", 7*24*time.Hour, "time after which deleted images are purged from the trash")
+	storeKind := flag.String("store", "disk", "where images are stored: disk or memory (images are lost on restart)")
+	memoryBudget := flag.Int64("memory-budget", 256<<20, "maximal size of images kept by the memory store in bytes (zero means no limit)")
 	flag.Parse()
 	log.Printf("start server on port %d", *port)
 
-	imageStore := service.NewDiskImageStore("img")
+	imageStore, err := newImageStore(*storeKind, *versionRetention, *memoryBudget)
+	if err != nil {
+		log.Fatal("cannot create image store: ", err)
+	}
+
+	// remove idle upload sessions, evict expired images and purge old trash items in background
+	janitor := service.NewJanitor(imageStore)
+	janitor.UploadSessionTTL = *uploadSessionTTL
+	janitor.TrashRetention = *trashRetention
+	go janitor.Run(context.Background())
 
 	imageServer := service.NewImageServer(imageStore)
 
@@ -25,6 +25,17 @@ type ImageServiceClient interface {
 	GetUploadedImagesTableString(ctx context.Context, in *GetUploadedImagesTableStringRequest, opts ...grpc.CallOption) (*GetUploadedImagesTableStringResponse, error)
 	UploadImage(ctx context.Context, opts ...grpc.CallOption) (ImageService_UploadImageClient, error)
 	DownloadImage(ctx context.Context, in *DownloadImageRequest, opts ...grpc.CallOption) (ImageService_DownloadImageClient, error)
+	ListImages(ctx context.Context, in *ListImagesRequest, opts ...grpc.CallOption) (*ListImagesResponse, error)
+	DeleteImage(ctx context.Context, in *DeleteImageRequest, opts ...grpc.CallOption) (*DeleteImageResponse, error)
+	GetImageInfo(ctx context.Context, in *GetImageInfoRequest, opts ...grpc.CallOption) (*GetImageInfoResponse, error)
+	CreateUploadSession(ctx context.Context, in *CreateUploadSessionRequest, opts ...grpc.CallOption) (*CreateUploadSessionResponse, error)
+	AppendUploadSession(ctx context.Context, opts ...grpc.CallOption) (ImageService_AppendUploadSessionClient, error)
+	GetUploadSession(ctx context.Context, in *GetUploadSessionRequest, opts ...grpc.CallOption) (*GetUploadSessionResponse, error)
+	FinalizeUploadSession(ctx context.Context, in *FinalizeUploadSessionRequest, opts ...grpc.CallOption) (*UploadImageResponse, error)
+	ListImageVersions(ctx context.Context, in *ListImageVersionsRequest, opts ...grpc.CallOption) (*ListImageVersionsResponse, error)
+	RestoreImageVersion(ctx context.Context, in *RestoreImageVersionRequest, opts ...grpc.CallOption) (*RestoreImageVersionResponse, error)
+	ListTrash(ctx context.Context, in *ListTrashRequest, opts ...grpc.CallOption) (*ListTrashResponse, error)
+	RestoreImage(ctx context.Context, in *RestoreImageRequest, opts ...grpc.CallOption) (*RestoreImageResponse, error)
 }
 
 type imageServiceClient struct {
@@ -110,6 +121,130 @@ func (x *imageServiceDownloadImageClient) Recv() (*DownloadImageResponse, error)
 	return m, nil
 }
 
+func (c *imageServiceClient) ListImages(ctx context.Context, in *ListImagesRequest, opts ...grpc.CallOption) (*ListImagesResponse, error) {
+	out := new(ListImagesResponse)
+	err := c.cc.Invoke(ctx, "/tages.ImageService/ListImages", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageServiceClient) DeleteImage(ctx context.Context, in *DeleteImageRequest, opts ...grpc.CallOption) (*DeleteImageResponse, error) {
+	out := new(DeleteImageResponse)
+	err := c.cc.Invoke(ctx, "/tages.ImageService/DeleteImage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageServiceClient) GetImageInfo(ctx context.Context, in *GetImageInfoRequest, opts ...grpc.CallOption) (*GetImageInfoResponse, error) {
+	out := new(GetImageInfoResponse)
+	err := c.cc.Invoke(ctx, "/tages.ImageService/GetImageInfo", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageServiceClient) CreateUploadSession(ctx context.Context, in *CreateUploadSessionRequest, opts ...grpc.CallOption) (*CreateUploadSessionResponse, error) {
+	out := new(CreateUploadSessionResponse)
+	err := c.cc.Invoke(ctx, "/tages.ImageService/CreateUploadSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageServiceClient) AppendUploadSession(ctx context.Context, opts ...grpc.CallOption) (ImageService_AppendUploadSessionClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ImageService_ServiceDesc.Streams[2], "/tages.ImageService/AppendUploadSession", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &imageServiceAppendUploadSessionClient{stream}
+	return x, nil
+}
+
+type ImageService_AppendUploadSessionClient interface {
+	Send(*AppendUploadSessionRequest) error
+	CloseAndRecv() (*AppendUploadSessionResponse, error)
+	grpc.ClientStream
+}
+
+type imageServiceAppendUploadSessionClient struct {
+	grpc.ClientStream
+}
+
+func (x *imageServiceAppendUploadSessionClient) Send(m *AppendUploadSessionRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *imageServiceAppendUploadSessionClient) CloseAndRecv() (*AppendUploadSessionResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(AppendUploadSessionResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *imageServiceClient) GetUploadSession(ctx context.Context, in *GetUploadSessionRequest, opts ...grpc.CallOption) (*GetUploadSessionResponse, error) {
+	out := new(GetUploadSessionResponse)
+	err := c.cc.Invoke(ctx, "/tages.ImageService/GetUploadSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageServiceClient) FinalizeUploadSession(ctx context.Context, in *FinalizeUploadSessionRequest, opts ...grpc.CallOption) (*UploadImageResponse, error) {
+	out := new(UploadImageResponse)
+	err := c.cc.Invoke(ctx, "/tages.ImageService/FinalizeUploadSession", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageServiceClient) ListImageVersions(ctx context.Context, in *ListImageVersionsRequest, opts ...grpc.CallOption) (*ListImageVersionsResponse, error) {
+	out := new(ListImageVersionsResponse)
+	err := c.cc.Invoke(ctx, "/tages.ImageService/ListImageVersions", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageServiceClient) RestoreImageVersion(ctx context.Context, in *RestoreImageVersionRequest, opts ...grpc.CallOption) (*RestoreImageVersionResponse, error) {
+	out := new(RestoreImageVersionResponse)
+	err := c.cc.Invoke(ctx, "/tages.ImageService/RestoreImageVersion", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageServiceClient) ListTrash(ctx context.Context, in *ListTrashRequest, opts ...grpc.CallOption) (*ListTrashResponse, error) {
+	out := new(ListTrashResponse)
+	err := c.cc.Invoke(ctx, "/tages.ImageService/ListTrash", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *imageServiceClient) RestoreImage(ctx context.Context, in *RestoreImageRequest, opts ...grpc.CallOption) (*RestoreImageResponse, error) {
+	out := new(RestoreImageResponse)
+	err := c.cc.Invoke(ctx, "/tages.ImageService/RestoreImage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ImageServiceServer is the server API for ImageService service.
 // All implementations must embed UnimplementedImageServiceServer
 // for forward compatibility
@@ -117,6 +252,17 @@ type ImageServiceServer interface {
 	GetUploadedImagesTableString(context.Context, *GetUploadedImagesTableStringRequest) (*GetUploadedImagesTableStringResponse, error)
 	UploadImage(ImageService_UploadImageServer) error
 	DownloadImage(*DownloadImageRequest, ImageService_DownloadImageServer) error
+	ListImages(context.Context, *ListImagesRequest) (*ListImagesResponse, error)
+	DeleteImage(context.Context, *DeleteImageRequest) (*DeleteImageResponse, error)
+	GetImageInfo(context.Context, *GetImageInfoRequest) (*GetImageInfoResponse, error)
+	CreateUploadSession(context.Context, *CreateUploadSessionRequest) (*CreateUploadSessionResponse, error)
+	AppendUploadSession(ImageService_AppendUploadSessionServer) error
+	GetUploadSession(context.Context, *GetUploadSessionRequest) (*GetUploadSessionResponse, error)
+	FinalizeUploadSession(context.Context, *FinalizeUploadSessionRequest) (*UploadImageResponse, error)
+	ListImageVersions(context.Context, *ListImageVersionsRequest) (*ListImageVersionsResponse, error)
+	RestoreImageVersion(context.Context, *RestoreImageVersionRequest) (*RestoreImageVersionResponse, error)
+	ListTrash(context.Context, *ListTrashRequest) (*ListTrashResponse, error)
+	RestoreImage(context.Context, *RestoreImageRequest) (*RestoreImageResponse, error)
 	mustEmbedUnimplementedImageServiceServer()
 }
 
@@ -133,6 +279,39 @@ func (UnimplementedImageServiceServer) UploadImage(ImageService_UploadImageServe
 func (UnimplementedImageServiceServer) DownloadImage(*DownloadImageRequest, ImageService_DownloadImageServer) error {
 	return status.Errorf(codes.Unimplemented, "method DownloadImage not implemented")
 }
+func (UnimplementedImageServiceServer) ListImages(context.Context, *ListImagesRequest) (*ListImagesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListImages not implemented")
+}
+func (UnimplementedImageServiceServer) DeleteImage(context.Context, *DeleteImageRequest) (*DeleteImageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteImage not implemented")
+}
+func (UnimplementedImageServiceServer) GetImageInfo(context.Context, *GetImageInfoRequest) (*GetImageInfoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetImageInfo not implemented")
+}
+func (UnimplementedImageServiceServer) CreateUploadSession(context.Context, *CreateUploadSessionRequest) (*CreateUploadSessionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateUploadSession not implemented")
+}
+func (UnimplementedImageServiceServer) AppendUploadSession(ImageService_AppendUploadSessionServer) error {
+	return status.Errorf(codes.Unimplemented, "method AppendUploadSession not implemented")
+}
+func (UnimplementedImageServiceServer) GetUploadSession(context.Context, *GetUploadSessionRequest) (*GetUploadSessionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUploadSession not implemented")
+}
+func (UnimplementedImageServiceServer) FinalizeUploadSession(context.Context, *FinalizeUploadSessionRequest) (*UploadImageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FinalizeUploadSession not implemented")
+}
+func (UnimplementedImageServiceServer) ListImageVersions(context.Context, *ListImageVersionsRequest) (*ListImageVersionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListImageVersions not implemented")
+}
+func (UnimplementedImageServiceServer) RestoreImageVersion(context.Context, *RestoreImageVersionRequest) (*RestoreImageVersionResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreImageVersion not implemented")
+}
+func (UnimplementedImageServiceServer) ListTrash(context.Context, *ListTrashRequest) (*ListTrashResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTrash not implemented")
+}
+func (UnimplementedImageServiceServer) RestoreImage(context.Context, *RestoreImageRequest) (*RestoreImageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreImage not implemented")
+}
 func (UnimplementedImageServiceServer) mustEmbedUnimplementedImageServiceServer() {}
 
 // UnsafeImageServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -211,6 +390,212 @@ func (x *imageServiceDownloadImageServer) Send(m *DownloadImageResponse) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _ImageService_ListImages_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListImagesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageServiceServer).ListImages(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tages.ImageService/ListImages",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageServiceServer).ListImages(ctx, req.(*ListImagesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageService_DeleteImage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteImageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageServiceServer).DeleteImage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tages.ImageService/DeleteImage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageServiceServer).DeleteImage(ctx, req.(*DeleteImageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageService_GetImageInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetImageInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageServiceServer).GetImageInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tages.ImageService/GetImageInfo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageServiceServer).GetImageInfo(ctx, req.(*GetImageInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageService_CreateUploadSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateUploadSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageServiceServer).CreateUploadSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tages.ImageService/CreateUploadSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageServiceServer).CreateUploadSession(ctx, req.(*CreateUploadSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageService_AppendUploadSession_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ImageServiceServer).AppendUploadSession(&imageServiceAppendUploadSessionServer{stream})
+}
+
+type ImageService_AppendUploadSessionServer interface {
+	SendAndClose(*AppendUploadSessionResponse) error
+	Recv() (*AppendUploadSessionRequest, error)
+	grpc.ServerStream
+}
+
+type imageServiceAppendUploadSessionServer struct {
+	grpc.ServerStream
+}
+
+func (x *imageServiceAppendUploadSessionServer) SendAndClose(m *AppendUploadSessionResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *imageServiceAppendUploadSessionServer) Recv() (*AppendUploadSessionRequest, error) {
+	m := new(AppendUploadSessionRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _ImageService_GetUploadSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUploadSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageServiceServer).GetUploadSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tages.ImageService/GetUploadSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageServiceServer).GetUploadSession(ctx, req.(*GetUploadSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageService_FinalizeUploadSession_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FinalizeUploadSessionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageServiceServer).FinalizeUploadSession(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tages.ImageService/FinalizeUploadSession",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageServiceServer).FinalizeUploadSession(ctx, req.(*FinalizeUploadSessionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageService_ListImageVersions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListImageVersionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageServiceServer).ListImageVersions(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tages.ImageService/ListImageVersions",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageServiceServer).ListImageVersions(ctx, req.(*ListImageVersionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageService_RestoreImageVersion_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreImageVersionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageServiceServer).RestoreImageVersion(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tages.ImageService/RestoreImageVersion",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageServiceServer).RestoreImageVersion(ctx, req.(*RestoreImageVersionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageService_ListTrash_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTrashRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageServiceServer).ListTrash(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tages.ImageService/ListTrash",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageServiceServer).ListTrash(ctx, req.(*ListTrashRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ImageService_RestoreImage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreImageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ImageServiceServer).RestoreImage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/tages.ImageService/RestoreImage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ImageServiceServer).RestoreImage(ctx, req.(*RestoreImageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ImageService_ServiceDesc is the grpc.ServiceDesc for ImageService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -222,6 +607,46 @@ var ImageService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetUploadedImagesTableString",
 			Handler:    _ImageService_GetUploadedImagesTableString_Handler,
 		},
+		{
+			MethodName: "ListImages",
+			Handler:    _ImageService_ListImages_Handler,
+		},
+		{
+			MethodName: "DeleteImage",
+			Handler:    _ImageService_DeleteImage_Handler,
+		},
+		{
+			MethodName: "GetImageInfo",
+			Handler:    _ImageService_GetImageInfo_Handler,
+		},
+		{
+			MethodName: "CreateUploadSession",
+			Handler:    _ImageService_CreateUploadSession_Handler,
+		},
+		{
+			MethodName: "GetUploadSession",
+			Handler:    _ImageService_GetUploadSession_Handler,
+		},
+		{
+			MethodName: "FinalizeUploadSession",
+			Handler:    _ImageService_FinalizeUploadSession_Handler,
+		},
+		{
+			MethodName: "ListImageVersions",
+			Handler:    _ImageService_ListImageVersions_Handler,
+		},
+		{
+			MethodName: "RestoreImageVersion",
+			Handler:    _ImageService_RestoreImageVersion_Handler,
+		},
+		{
+			MethodName: "ListTrash",
+			Handler:    _ImageService_ListTrash_Handler,
+		},
+		{
+			MethodName: "RestoreImage",
+			Handler:    _ImageService_RestoreImage_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -234,6 +659,11 @@ var ImageService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _ImageService_DownloadImage_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "AppendUploadSession",
+			Handler:       _ImageService_AppendUploadSession_Handler,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "image_service.proto",
 }
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// name of the folder in image folder keeping image content by its SHA-256 (identical content is stored once)
+const blobsFolderName = ".blobs"
+
+// blob is a struct to operate content shared by images, previous versions and trash items with identical checksum.
+// Their files are hard links to the blob file, so the content takes disk space once
+// and files are never modified in place, so sharing is safe.
+type blob struct {
+	// number of references from the catalog (and saves in progress)
+	refs int
+	// size of the content in bytes
+	size int64
+}
+
+// StorageUsage is a struct to operate disk usage of the store
+type StorageUsage struct {
+	// total size of images, previous versions and deleted images as if each of them was stored separately
+	Logical int64
+	// total size of stored content (identical content is stored once)
+	Physical int64
+}
+
+// function to get path of the blob file with the content
+func blobFilePath(imageFolder string, checksum string) string {
+	return filepath.Join(imageFolder, blobsFolderName, checksum)
+}
+
+// function to atomically replace the file with a hard link to the target file
+func replaceWithLink(target string, path string) error {
+	linkPath := path + ".link"
+	os.Remove(linkPath)
+	err := os.Link(target, linkPath)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(linkPath, path)
+	if err != nil {
+		os.Remove(linkPath)
+		return err
+	}
+	return nil
+}
+
+// acquireBlob adds a reference to the blob with the content of the file and turns the file into a link to the blob
+// (the file becomes the blob if there is no blob with the checksum yet).
+// Blob files are linked and removed under the lock of their checksum, the store lock is held only to count references.
+func (store *DiskImageStore) acquireBlob(filePath string, checksum string, size int64) error {
+	// lock blob for writing
+	unlock := store.blobLocks.Lock(checksum)
+	defer unlock()
+
+	// check if the blob is stored already (it can't be changed by others while the blob is locked)
+	store.mutex.RLock()
+	stored := store.blobs[checksum] != nil
+	store.mutex.RUnlock()
+
+	// trying to replace the file with a link to the stored blob
+	blobPath := blobFilePath(store.imageFolder, checksum)
+	if stored {
+		err := replaceWithLink(blobPath, filePath)
+		if err == nil {
+			store.addBlobReference(checksum, size)
+			return nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("cannot link image file to blob: %w", err)
+		}
+		log.Printf("blob %s is missing, storing it again", checksum)
+	}
+
+	// trying to store the file as the blob (the blobs folder is created on startup, but may be removed since)
+	err := os.MkdirAll(filepath.Dir(blobPath), 0755)
+	if err != nil {
+		return fmt.Errorf("cannot create blobs folder: %w", err)
+	}
+	os.Remove(blobPath)
+	err = os.Link(filePath, blobPath)
+	if err != nil {
+		return fmt.Errorf("cannot store image blob: %w", err)
+	}
+	store.addBlobReference(checksum, size)
+
+	return nil
+}
+
+// addBlobReference counts one more reference to the blob (caller must hold the blob lock)
+func (store *DiskImageStore) addBlobReference(checksum string, size int64) {
+	// lock in-memory storage
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	b := store.blobs[checksum]
+	if b == nil {
+		b = &blob{size: size}
+		store.blobs[checksum] = b
+	}
+	b.refs++
+}
+
+// releaseBlob removes a reference to the blob and removes the blob file with the last one (caller must not hold the store lock)
+func (store *DiskImageStore) releaseBlob(checksum string) {
+	// lock blob for writing
+	unlock := store.blobLocks.Lock(checksum)
+	defer unlock()
+
+	// count one reference less
+	store.mutex.Lock()
+	b := store.blobs[checksum]
+	unreferenced := false
+	if b != nil {
+		b.refs--
+		if b.refs <= 0 {
+			delete(store.blobs, checksum)
+			unreferenced = true
+		}
+	}
+	store.mutex.Unlock()
+	if !unreferenced {
+		return
+	}
+
+	// trying to remove blob file
+	blobPath := blobFilePath(store.imageFolder, checksum)
+	err := os.Remove(blobPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("cannot remove blob file %s: %v", blobPath, err)
+	}
+}
+
+// releaseImageBlobs removes references of the image and its previous versions (caller must not hold the store lock)
+func (store *DiskImageStore) releaseImageBlobs(imageInfo *ImageInfo, versions []*ImageInfo) {
+	store.releaseBlob(imageInfo.Checksum)
+	for _, versionInfo := range versions {
+		store.releaseBlob(versionInfo.Checksum)
+	}
+}
+
+// Usage returns logical size of images, previous versions and deleted images and physical size of their content
+func (store *DiskImageStore) Usage() StorageUsage {
+	// lock in-memory storage for reading
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	usage := StorageUsage{Logical: logicalSize(store.images, store.versions, store.trash)}
+	for _, b := range store.blobs {
+		usage.Physical += b.size
+	}
+
+	return usage
+}
+
+// logicalSize returns total size of images, their previous versions and deleted images (caller must hold the lock of the catalog)
+func logicalSize(images map[string]*ImageInfo, versions map[string][]*ImageInfo, trash map[string]*TrashItem) int64 {
+	size := int64(0)
+	for imageName, imageInfo := range images {
+		size += imageInfo.Size
+		for _, versionInfo := range versions[imageName] {
+			size += versionInfo.Size
+		}
+	}
+	for _, item := range trash {
+		size += item.ImageInfo.Size
+		for _, versionInfo := range item.Versions {
+			size += versionInfo.Size
+		}
+	}
+
+	return size
+}
+
+// scanBlobsFolder links files of images, previous versions and trash items to blobs and counts references of blobs.
+// Files stored before the blobs (or copies of the same content) are linked to the blob once their checksum is verified
+// and blob files nobody references are removed.
+func scanBlobsFolder(imageFolder string, index *imageIndex) (map[string]*blob, error) {
+	// trying to create blobs folder if it doesn't exist yet
+	blobsFolder := filepath.Join(imageFolder, blobsFolderName)
+	err := os.MkdirAll(blobsFolder, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create blobs folder: %w", err)
+	}
+
+	// collect files referenced by the index with checksums of their content
+	paths := make(map[string]string)
+	for imageName, imageInfo := range index.Images {
+		paths[filepath.Join(imageFolder, imageName+imageInfo.Type)] = imageInfo.Checksum
+		for _, versionInfo := range index.Versions[imageName] {
+			paths[versionFilePath(imageFolder, imageName, versionInfo.Version, versionInfo.Type)] = versionInfo.Checksum
+		}
+	}
+	for _, item := range index.Trash {
+		_, trashPaths := trashItemPaths(imageFolder, item)
+		paths[trashPaths[0]] = item.ImageInfo.Checksum
+		for _, versionInfo := range item.Versions {
+			paths[filepath.Join(trashPaths[1], fmt.Sprintf("%d%s", versionInfo.Version, versionInfo.Type))] = versionInfo.Checksum
+		}
+	}
+
+	// link the files to blobs counting references
+	blobs := make(map[string]*blob)
+	for path, checksum := range paths {
+		fileInfo, err := os.Stat(path)
+		if err != nil || checksum == "" {
+			continue
+		}
+
+		blobPath := blobFilePath(imageFolder, checksum)
+		blobInfo, blobErr := os.Stat(blobPath)
+		if blobErr != nil || !os.SameFile(fileInfo, blobInfo) {
+			// check that content of the file is really the blob before linking them
+			if actualChecksum, err := fileChecksum(path); err != nil || actualChecksum != checksum {
+				log.Printf("content of %s doesn't match its checksum, leaving it out of blobs", path)
+				continue
+			}
+
+			if blobErr != nil {
+				err = os.Link(path, blobPath)
+			} else {
+				log.Printf("linking %s to the blob with the same content", path)
+				err = replaceWithLink(blobPath, path)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("cannot link %s to blob: %w", path, err)
+			}
+		}
+
+		if blobs[checksum] == nil {
+			blobs[checksum] = &blob{size: fileInfo.Size()}
+		}
+		blobs[checksum].refs++
+	}
+
+	// trying to read blobs folder entries
+	entries, err := os.ReadDir(blobsFolder)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read blobs folder: %w", err)
+	}
+
+	// remove blobs nobody references
+	for _, entry := range entries {
+		if blobs[entry.Name()] == nil {
+			log.Printf("removing unreferenced blob %s", entry.Name())
+			os.RemoveAll(filepath.Join(blobsFolder, entry.Name()))
+		}
+	}
+
+	return blobs, nil
+}
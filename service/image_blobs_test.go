@@ -0,0 +1,92 @@
+package service_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MrPark97/tages/service"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskImageStoreDeduplicatesContent(t *testing.T) {
+	t.Parallel()
+
+	testImageFolder := t.TempDir()
+	imageStore, err := service.NewDiskImageStore(testImageFolder, service.WithVersionRetention(0))
+	require.NoError(t, err)
+
+	imageData, err := os.ReadFile("../tmp/laptop.jpeg")
+	require.NoError(t, err)
+	imageSize := int64(len(imageData))
+
+	// identical uploads under several names share the content
+	for _, imageName := range []string{"laptop", "laptop-copy", "notebook"} {
+		_, err = imageStore.Save(&service.ImageInfo{ImageName: imageName, Type: ".jpeg"}, bytes.NewReader(imageData))
+		require.NoError(t, err)
+	}
+	requireSameFile(t, filepath.Join(testImageFolder, "laptop.jpeg"), filepath.Join(testImageFolder, "laptop-copy.jpeg"))
+	requireBlobs(t, testImageFolder, 1)
+	require.Equal(t, service.StorageUsage{Logical: 3 * imageSize, Physical: imageSize}, imageStore.Usage())
+
+	// the blob stays while anybody references it
+	err = imageStore.Delete("laptop")
+	require.NoError(t, err)
+	_, err = imageStore.PurgeTrash(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	_, err = imageStore.Save(&service.ImageInfo{ImageName: "notebook", Type: ".png"}, bytes.NewReader(randomPNG(t, 8, 8)))
+	require.NoError(t, err)
+	requireBlobs(t, testImageFolder, 2)
+	require.Equal(t, downloadTestImage(t, imageStore, "laptop-copy"), imageData)
+
+	// the blob is removed with the last reference
+	err = imageStore.Delete("laptop-copy")
+	require.NoError(t, err)
+	_, err = imageStore.PurgeTrash(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	requireBlobs(t, testImageFolder, 1)
+
+	report, err := imageStore.CheckConsistency()
+	require.NoError(t, err)
+	require.True(t, report.Consistent())
+}
+
+func TestDiskImageStoreDeduplicatesExistingFiles(t *testing.T) {
+	t.Parallel()
+
+	// copies lying in the folder before the blobs are linked to the same blob on startup
+	testImageFolder := t.TempDir()
+	copyTestFile(t, "../tmp/laptop.jpeg", filepath.Join(testImageFolder, "laptop.jpeg"))
+	copyTestFile(t, "../tmp/laptop.jpeg", filepath.Join(testImageFolder, "notebook.jpeg"))
+	copyTestFile(t, "../tmp/macbook.png", filepath.Join(testImageFolder, "macbook.png"))
+
+	imageStore, err := service.NewDiskImageStore(testImageFolder)
+	require.NoError(t, err)
+	requireSameFile(t, filepath.Join(testImageFolder, "laptop.jpeg"), filepath.Join(testImageFolder, "notebook.jpeg"))
+	requireBlobs(t, testImageFolder, 2)
+	usage := imageStore.Usage()
+	require.EqualValues(t, 2*140097+68211, usage.Logical)
+	require.EqualValues(t, 140097+68211, usage.Physical)
+
+	// unreferenced blobs are removed on startup
+	require.NoError(t, os.WriteFile(filepath.Join(testImageFolder, ".blobs", "unknown"), []byte("data"), 0644))
+	_, err = service.NewDiskImageStore(testImageFolder)
+	require.NoError(t, err)
+	requireBlobs(t, testImageFolder, 2)
+}
+
+func requireSameFile(t *testing.T, path string, otherPath string) {
+	fileInfo, err := os.Stat(path)
+	require.NoError(t, err)
+	otherFileInfo, err := os.Stat(otherPath)
+	require.NoError(t, err)
+	require.True(t, os.SameFile(fileInfo, otherFileInfo))
+}
+
+func requireBlobs(t *testing.T, imageFolder string, count int) {
+	entries, err := os.ReadDir(filepath.Join(imageFolder, ".blobs"))
+	require.NoError(t, err)
+	require.Len(t, entries, count)
+}
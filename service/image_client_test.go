@@ -4,29 +4,43 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"image"
+	"image/color/palette"
+	"image/gif"
+	"image/png"
 	"io"
+	"math/rand"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/MrPark97/tages/pb"
 	"github.com/MrPark97/tages/service"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/image/bmp"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestClientUploadImage(t *testing.T) {
 	t.Parallel()
 
-	testImageFolder := "../tmp"
-	imageStore := service.NewDiskImageStore(testImageFolder)
+	testImageFolder := t.TempDir()
+	imageStore, err := service.NewDiskImageStore(testImageFolder)
+	require.NoError(t, err)
 
 	serverAddress := startTestImageServer(t, imageStore)
 	imageClient := newTestImageClient(t, serverAddress)
 
-	imagePath := fmt.Sprintf("%s/laptop.jpeg", testImageFolder)
+	imagePath := "../tmp/laptop.jpeg"
 	file, err := os.Open(imagePath)
 	require.NoError(t, err)
 	defer file.Close()
@@ -84,13 +98,14 @@ func TestClientUploadImage(t *testing.T) {
 func TestClientDownloadImage(t *testing.T) {
 	t.Parallel()
 
-	testImageFolder := "../tmp"
-	imageStore := service.NewDiskImageStore(testImageFolder)
+	testImageFolder := t.TempDir()
+	imageStore, err := service.NewDiskImageStore(testImageFolder)
+	require.NoError(t, err)
 
 	serverAddress := startTestImageServer(t, imageStore)
 	imageClient := newTestImageClient(t, serverAddress)
 
-	imagePath := fmt.Sprintf("%s/laptop.jpeg", testImageFolder)
+	imagePath := "../tmp/laptop.jpeg"
 	file, err := os.Open(imagePath)
 	require.NoError(t, err)
 	defer file.Close()
@@ -153,9 +168,18 @@ func TestClientDownloadImage(t *testing.T) {
 	require.NoError(t, err)
 
 	// trying to get image info
-	_, err = stream1.Recv()
+	res1, err := stream1.Recv()
 	require.NoError(t, err)
 
+	info := res1.GetInfo()
+	require.Equal(t, imageName, info.GetName())
+	require.Equal(t, imageType, info.GetType())
+	require.EqualValues(t, size, info.GetSize())
+	require.EqualValues(t, 1500, info.GetWidth())
+	require.EqualValues(t, 1000, info.GetHeight())
+	require.Equal(t, "jpeg", info.GetFormat())
+	require.Equal(t, "YCbCr", info.GetColorModel())
+
 	// init variables for bytes of image and image size
 	imageData := bytes.Buffer{}
 	imageSize := 0
@@ -180,7 +204,7 @@ func TestClientDownloadImage(t *testing.T) {
 	}
 
 	// trying to create file
-	file, err = os.Create(imagePath)
+	file, err = os.Create(fmt.Sprintf("%s/downloaded%s", testImageFolder, imageType))
 	require.NoError(t, err)
 
 	// trying to write data to file
@@ -190,10 +214,363 @@ func TestClientDownloadImage(t *testing.T) {
 	require.EqualValues(t, size, imageSize)
 }
 
+func TestClientDownloadImageRange(t *testing.T) {
+	t.Parallel()
+
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	serverAddress := startTestImageServer(t, imageStore)
+	imageClient := newTestImageClient(t, serverAddress)
+
+	imageData, err := os.ReadFile("../tmp/laptop.jpeg")
+	require.NoError(t, err)
+	res, err := uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".jpeg"}, imageData)
+	require.NoError(t, err)
+
+	// the range is sent with info of the whole image
+	info, rangeData, err := downloadTestImageRange(imageClient, &pb.DownloadImageRequest{Name: "laptop", Offset: 1000, Length: 5000})
+	require.NoError(t, err)
+	require.EqualValues(t, len(imageData), info.GetSize())
+	require.Equal(t, imageData[1000:6000], rangeData)
+
+	// the rest of the image is sent if the image wasn't changed
+	_, rangeData, err = downloadTestImageRange(imageClient, &pb.DownloadImageRequest{Name: "laptop", Offset: 6000, ExpectedChecksum: res.GetChecksum()})
+	require.NoError(t, err)
+	require.Equal(t, imageData[6000:], rangeData)
+
+	_, rangeData, err = downloadTestImageRange(imageClient, &pb.DownloadImageRequest{Name: "laptop", Offset: uint64(len(imageData))})
+	require.NoError(t, err)
+	require.Empty(t, rangeData)
+
+	_, _, err = downloadTestImageRange(imageClient, &pb.DownloadImageRequest{Name: "laptop", Offset: uint64(len(imageData)) + 1})
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+
+	_, _, err = downloadTestImageRange(imageClient, &pb.DownloadImageRequest{Name: "laptop", ExpectedChecksum: strings.Repeat("0", 64)})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+}
+
+func TestClientDownloadImageNotModified(t *testing.T) {
+	t.Parallel()
+
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	serverAddress := startTestImageServer(t, imageStore)
+	imageClient := newTestImageClient(t, serverAddress)
+
+	imageData, err := os.ReadFile("../tmp/laptop.jpeg")
+	require.NoError(t, err)
+	res, err := uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".jpeg"}, imageData)
+	require.NoError(t, err)
+	infoRes, err := imageClient.GetImageInfo(context.Background(), &pb.GetImageInfoRequest{Name: "laptop"})
+	require.NoError(t, err)
+	updatedAt := infoRes.GetInfo().GetUpdatedAt().AsTime()
+
+	testCases := []struct {
+		name        string
+		req         *pb.DownloadImageRequest
+		notModified bool
+	}{
+		{"current checksum", &pb.DownloadImageRequest{Name: "laptop", IfNoneMatch: res.GetChecksum()}, true},
+		{"stale checksum", &pb.DownloadImageRequest{Name: "laptop", IfNoneMatch: strings.Repeat("0", 64)}, false},
+		{"copy updated at the same time", &pb.DownloadImageRequest{Name: "laptop", IfModifiedSince: timestamppb.New(updatedAt)}, true},
+		{"copy updated before", &pb.DownloadImageRequest{Name: "laptop", IfModifiedSince: timestamppb.New(updatedAt.Add(-time.Second))}, false},
+		{"checksum takes precedence", &pb.DownloadImageRequest{Name: "laptop", IfNoneMatch: strings.Repeat("0", 64), IfModifiedSince: timestamppb.New(updatedAt)}, false},
+	}
+
+	for _, tc := range testCases {
+		info, data, err := downloadTestImageRange(imageClient, tc.req)
+		require.NoError(t, err, tc.name)
+		require.Equal(t, tc.notModified, info.GetNotModified(), tc.name)
+		require.Equal(t, res.GetChecksum(), info.GetChecksum(), tc.name)
+		if tc.notModified {
+			require.Empty(t, data, tc.name)
+		} else {
+			require.Equal(t, imageData, data, tc.name)
+		}
+	}
+}
+
+func TestClientUploadImageStampsTimes(t *testing.T) {
+	t.Parallel()
+
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	serverAddress := startTestImageServer(t, imageStore)
+	imageClient := newTestImageClient(t, serverAddress)
+
+	imageData, err := os.ReadFile("../tmp/laptop.jpeg")
+	require.NoError(t, err)
+
+	// client clock is ignored, client time is kept as source modification time
+	clientTime := time.Date(1999, 1, 1, 12, 0, 0, 0, time.UTC)
+	uploadTime := time.Now()
+	_, err = uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".jpeg", UpdatedAt: timestamppb.New(clientTime)}, imageData)
+	require.NoError(t, err)
+
+	infoRes, err := imageClient.GetImageInfo(context.Background(), &pb.GetImageInfoRequest{Name: "laptop"})
+	require.NoError(t, err)
+	createdAt := infoRes.GetInfo().GetCreatedAt().AsTime()
+	require.False(t, createdAt.Before(uploadTime))
+	require.True(t, createdAt.Equal(infoRes.GetInfo().GetUpdatedAt().AsTime()))
+	require.True(t, clientTime.Equal(infoRes.GetInfo().GetSourceModifiedAt().AsTime()))
+
+	// source_modified_at takes precedence over updated_at, creation time is kept on update
+	sourceModifiedAt := time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)
+	_, err = uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".jpeg", UpdatedAt: timestamppb.New(clientTime), SourceModifiedAt: timestamppb.New(sourceModifiedAt)}, imageData)
+	require.NoError(t, err)
+
+	listRes, err := imageClient.ListImages(context.Background(), &pb.ListImagesRequest{})
+	require.NoError(t, err)
+	require.Len(t, listRes.GetImages(), 1)
+	info := listRes.GetImages()[0]
+	require.True(t, createdAt.Equal(info.GetCreatedAt().AsTime()))
+	require.True(t, info.GetUpdatedAt().AsTime().After(createdAt))
+	require.True(t, sourceModifiedAt.Equal(info.GetSourceModifiedAt().AsTime()))
+
+	// source modification time is unset if the client doesn't know it
+	_, err = uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".jpeg"}, imageData)
+	require.NoError(t, err)
+
+	infoRes, err = imageClient.GetImageInfo(context.Background(), &pb.GetImageInfoRequest{Name: "laptop"})
+	require.NoError(t, err)
+	require.Nil(t, infoRes.GetInfo().GetSourceModifiedAt())
+}
+
+// function to download the requested range of the image and return image info with the received data
+func downloadTestImageRange(imageClient pb.ImageServiceClient, req *pb.DownloadImageRequest) (*pb.Info, []byte, error) {
+	stream, err := imageClient.DownloadImage(context.Background(), req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := stream.Recv()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	imageData := bytes.Buffer{}
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		imageData.Write(res.GetChunkData())
+	}
+
+	return res.GetInfo(), imageData.Bytes(), nil
+}
+
+func TestClientUploadLargeImage(t *testing.T) {
+	t.Parallel()
+
+	testImageFolder := t.TempDir()
+	imageStore, err := service.NewDiskImageStore(testImageFolder)
+	require.NoError(t, err)
+
+	serverAddress := startTestImageServer(t, imageStore)
+	imageClient := newTestImageClient(t, serverAddress)
+
+	// noise doesn't compress, so the image is several megabytes
+	imageData := randomPNG(t, 1024, 1024)
+	require.Greater(t, len(imageData), 1<<20)
+
+	res, err := uploadTestImage(t, imageClient, &pb.Info{Name: "noise", Type: ".png"}, imageData)
+	require.NoError(t, err)
+	require.Equal(t, "noise", res.GetName())
+	require.EqualValues(t, len(imageData), res.GetSize())
+
+	savedImageData, err := os.ReadFile(filepath.Join(testImageFolder, "noise.png"))
+	require.NoError(t, err)
+	require.Equal(t, imageData, savedImageData)
+
+	// no temporary files are left in the image folder
+	entries, err := os.ReadDir(testImageFolder)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		require.Contains(t, []string{"noise.png", ".index.json", ".staging", ".versions", ".trash", ".blobs"}, entry.Name())
+	}
+}
+
+func TestClientUploadImageChecksContent(t *testing.T) {
+	t.Parallel()
+
+	testImageFolder := t.TempDir()
+	imageStore, err := service.NewDiskImageStore(testImageFolder)
+	require.NoError(t, err)
+
+	serverAddress := startTestImageServer(t, imageStore)
+	imageClient := newTestImageClient(t, serverAddress)
+
+	jpegData, err := os.ReadFile("../tmp/laptop.jpeg")
+	require.NoError(t, err)
+
+	img := image.NewPaletted(image.Rect(0, 0, 16, 8), palette.Plan9)
+	gifData := bytes.Buffer{}
+	require.NoError(t, gif.Encode(&gifData, img, nil))
+	bmpData := bytes.Buffer{}
+	require.NoError(t, bmp.Encode(&bmpData, img))
+
+	// images matching their types are accepted
+	for _, testCase := range []struct {
+		imageType string
+		imageData []byte
+	}{
+		{".jpeg", jpegData},
+		{".JPG", jpegData},
+		{".png", randomPNG(t, 8, 8)},
+		{".gif", gifData.Bytes()},
+		{".bmp", bmpData.Bytes()},
+	} {
+		_, err := uploadTestImage(t, imageClient, &pb.Info{Name: "valid", Type: testCase.imageType}, testCase.imageData)
+		require.NoError(t, err, testCase.imageType)
+	}
+
+	// non-images and images of other formats are rejected
+	for _, testCase := range []struct {
+		imageType string
+		imageData []byte
+	}{
+		{".png", []byte("#!/bin/sh\nrm -rf /\n")},
+		{".png", nil},
+		{".png", jpegData},
+		{".jpeg", gifData.Bytes()},
+		{".webp", bmpData.Bytes()},
+		{".png", randomPNG(t, 8, 8)[:20]},
+	} {
+		_, err := uploadTestImage(t, imageClient, &pb.Info{Name: "invalid", Type: testCase.imageType}, testCase.imageData)
+		require.Equal(t, codes.InvalidArgument, status.Code(err), testCase.imageType)
+	}
+
+	// rejected uploads leave nothing on disk
+	images := imageStore.List(service.ListOptions{})
+	require.Len(t, images, 1)
+	require.Equal(t, "valid", images[0].ImageName)
+	require.NoFileExists(t, filepath.Join(testImageFolder, "invalid.png"))
+}
+
+func TestClientUploadImageVerifiesChecksum(t *testing.T) {
+	t.Parallel()
+
+	testImageFolder := t.TempDir()
+	imageStore, err := service.NewDiskImageStore(testImageFolder)
+	require.NoError(t, err)
+
+	serverAddress := startTestImageServer(t, imageStore)
+	imageClient := newTestImageClient(t, serverAddress)
+
+	imageData, err := os.ReadFile("../tmp/laptop.jpeg")
+	require.NoError(t, err)
+	checksum := sha256.Sum256(imageData)
+	expectedChecksum := hex.EncodeToString(checksum[:])
+
+	// content not matching declared checksum is rejected
+	otherChecksum := sha256.Sum256([]byte("other content"))
+	_, err = uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".jpeg", Checksum: hex.EncodeToString(otherChecksum[:])}, imageData)
+	require.Equal(t, codes.DataLoss, status.Code(err))
+	require.Empty(t, imageStore.List(service.ListOptions{}))
+
+	_, err = uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".jpeg", Checksum: "not a checksum"}, imageData)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// matching (and absent) checksum is accepted and the computed one is returned
+	res, err := uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".jpeg", Checksum: strings.ToUpper(expectedChecksum)}, imageData)
+	require.NoError(t, err)
+	require.Equal(t, expectedChecksum, res.GetChecksum())
+
+	res, err = uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".jpeg"}, imageData)
+	require.NoError(t, err)
+	require.Equal(t, expectedChecksum, res.GetChecksum())
+
+	// checksum is sent in the download header
+	stream, err := imageClient.DownloadImage(context.Background(), &pb.DownloadImageRequest{Name: "laptop"})
+	require.NoError(t, err)
+	infoRes, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, expectedChecksum, infoRes.GetInfo().GetChecksum())
+}
+
+func TestClientUploadImageConflictPolicy(t *testing.T) {
+	t.Parallel()
+
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	serverAddress := startTestImageServer(t, imageStore)
+	imageClient := newTestImageClient(t, serverAddress)
+
+	imageData, err := os.ReadFile("../tmp/laptop.jpeg")
+	require.NoError(t, err)
+
+	_, err = uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".jpeg", ConflictPolicy: pb.ConflictPolicy_CONFLICT_POLICY_FAIL_IF_EXISTS}, imageData)
+	require.NoError(t, err)
+
+	_, err = uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".png", ConflictPolicy: pb.ConflictPolicy_CONFLICT_POLICY_FAIL_IF_EXISTS}, randomPNG(t, 8, 8))
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	for _, expectedName := range []string{"laptop-1", "laptop-2"} {
+		res, err := uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".jpeg", ConflictPolicy: pb.ConflictPolicy_CONFLICT_POLICY_AUTO_RENAME}, imageData)
+		require.NoError(t, err)
+		require.Equal(t, expectedName, res.GetName())
+	}
+
+	res, err := uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".jpeg"}, imageData)
+	require.NoError(t, err)
+	require.Equal(t, "laptop", res.GetName())
+	require.EqualValues(t, 2, res.GetVersion())
+
+	_, err = uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".jpeg", ConflictPolicy: pb.ConflictPolicy(10)}, imageData)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	images := imageStore.List(service.ListOptions{})
+	require.Len(t, images, 3)
+}
+
+func TestClientUploadImageExpectedVersion(t *testing.T) {
+	t.Parallel()
+
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	serverAddress := startTestImageServer(t, imageStore)
+	imageClient := newTestImageClient(t, serverAddress)
+
+	imageData, err := os.ReadFile("../tmp/laptop.jpeg")
+	require.NoError(t, err)
+
+	// there is no image to expect a version of
+	_, err = uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".jpeg", ExpectedVersion: 1}, imageData)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	res, err := uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".jpeg"}, imageData)
+	require.NoError(t, err)
+
+	// both editors read version 1, the second one is rejected after the first one saves its edit
+	res, err = uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".jpeg", ExpectedVersion: res.GetVersion()}, imageData)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, res.GetVersion())
+
+	_, err = uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".jpeg", ExpectedVersion: 1}, imageData)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	info, err := imageClient.GetImageInfo(context.Background(), &pb.GetImageInfoRequest{Name: "laptop"})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, info.GetInfo().GetVersion())
+
+	_, err = uploadTestImage(t, imageClient, &pb.Info{Name: "laptop", Type: ".jpeg", ExpectedVersion: 2, ConflictPolicy: pb.ConflictPolicy_CONFLICT_POLICY_AUTO_RENAME}, imageData)
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
 func TestClientGetUploadedImagesTableString(t *testing.T) {
 	t.Parallel()
 
-	imageStore := service.NewDiskImageStore("../tmp")
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
 	serverAddress := startTestImageServer(t, imageStore)
 	imageClient := newTestImageClient(t, serverAddress)
 
@@ -204,7 +581,52 @@ func TestClientGetUploadedImagesTableString(t *testing.T) {
 	res, err := imageClient.GetUploadedImagesTableString(context.Background(), req)
 	require.NoError(t, err)
 	require.NotNil(t, res)
-	require.Equal(t, "Имя файла | Дата создания       | Дата обновления\n", res.GetTable())
+	require.Equal(t, "Имя файла | Дата создания       | Дата обновления     | Размер | Разрешение | Формат | Цветовая модель\n"+
+		"Логический размер: 0 | Физический размер: 0\n", res.GetTable())
+}
+
+func uploadTestImage(t *testing.T, imageClient pb.ImageServiceClient, info *pb.Info, imageData []byte) (*pb.UploadImageResponse, error) {
+	stream, err := imageClient.UploadImage(context.Background())
+	require.NoError(t, err)
+
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: info,
+		},
+	})
+	if err != nil {
+		return stream.CloseAndRecv()
+	}
+
+	for offset := 0; offset < len(imageData); offset += 64 * 1024 {
+		end := offset + 64*1024
+		if end > len(imageData) {
+			end = len(imageData)
+		}
+
+		err = stream.Send(&pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_ChunkData{
+				ChunkData: imageData[offset:end],
+			},
+		})
+		if err != nil {
+			break
+		}
+	}
+
+	return stream.CloseAndRecv()
+}
+
+func randomPNG(t *testing.T, width int, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	_, err := rand.Read(img.Pix)
+	require.NoError(t, err)
+
+	imageData := bytes.Buffer{}
+	err = png.Encode(&imageData, img)
+	require.NoError(t, err)
+
+	return imageData.Bytes()
 }
 
 func newTestImageClient(t *testing.T, serverAddress string) pb.ImageServiceClient {
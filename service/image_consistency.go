@@ -0,0 +1,95 @@
+package service
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ConsistencyReport is a struct to operate result of the image folder consistency check.
+// Paths are relative to the image folder.
+type ConsistencyReport struct {
+	// files lying in the image folder which no image, version, upload session or trash item references
+	UnreferencedFiles []string
+	// files referenced by images, versions or trash items which are missing
+	MissingFiles []string
+}
+
+// Consistent reports if the image folder matches the catalog
+func (report *ConsistencyReport) Consistent() bool {
+	return len(report.UnreferencedFiles) == 0 && len(report.MissingFiles) == 0
+}
+
+// CheckConsistency compares files lying in the image folder with images, versions, upload sessions and trash items of the catalog.
+// Temporary files of writes in progress are skipped, but files written concurrently with the check
+// may still be reported, so the report is reliable only for an idle store (e.g. on startup).
+func (store *DiskImageStore) CheckConsistency() (*ConsistencyReport, error) {
+	// collect files referenced by the catalog (the index and data of upload sessions may be not written yet, so they are never missing)
+	referencedFiles := make(map[string]bool)
+	optionalFiles := map[string]bool{imageIndexFileName: true}
+	store.mutex.RLock()
+	for imageName, imageInfo := range store.images {
+		referencedFiles[imageName+imageInfo.Type] = true
+		for _, versionInfo := range store.versions[imageName] {
+			referencedFiles[filepath.Join(versionsFolderName, imageName, fmt.Sprintf("%d%s", versionInfo.Version, versionInfo.Type))] = true
+		}
+	}
+	for trashID, item := range store.trash {
+		referencedFiles[filepath.Join(trashFolderName, trashID, item.ImageInfo.ImageName+item.ImageInfo.Type)] = true
+		for _, versionInfo := range item.Versions {
+			referencedFiles[filepath.Join(trashFolderName, trashID, versionsFolderName, fmt.Sprintf("%d%s", versionInfo.Version, versionInfo.Type))] = true
+		}
+	}
+	for checksum := range store.blobs {
+		referencedFiles[filepath.Join(blobsFolderName, checksum)] = true
+	}
+	for sessionID := range store.sessions {
+		optionalFiles[filepath.Join(stagingFolderName, sessionID)] = true
+	}
+	store.mutex.RUnlock()
+
+	report := &ConsistencyReport{}
+
+	// trying to walk through the image folder looking for unreferenced files
+	foundFiles := make(map[string]bool)
+	err := filepath.WalkDir(store.imageFolder, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		// skip temporary files of writes in progress
+		relPath, err := filepath.Rel(store.imageFolder, path)
+		if err != nil {
+			return err
+		}
+		if !strings.ContainsRune(relPath, filepath.Separator) && isStaleServiceFile(relPath) {
+			return nil
+		}
+
+		foundFiles[relPath] = true
+		if !referencedFiles[relPath] && !optionalFiles[relPath] {
+			report.UnreferencedFiles = append(report.UnreferencedFiles, relPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot walk image folder: %w", err)
+	}
+
+	// find referenced files which are missing
+	for relPath := range referencedFiles {
+		if !foundFiles[relPath] {
+			report.MissingFiles = append(report.MissingFiles, relPath)
+		}
+	}
+
+	sort.Strings(report.UnreferencedFiles)
+	sort.Strings(report.MissingFiles)
+
+	return report, nil
+}
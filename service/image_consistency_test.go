@@ -0,0 +1,65 @@
+package service_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MrPark97/tages/service"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskImageStoreReplacesImageOfAnotherType(t *testing.T) {
+	t.Parallel()
+
+	for _, versionRetention := range []int{0, 5} {
+		testImageFolder := t.TempDir()
+		imageStore, err := service.NewDiskImageStore(testImageFolder, service.WithVersionRetention(versionRetention))
+		require.NoError(t, err)
+
+		saveTestImage(t, imageStore, "laptop", "../tmp/laptop.jpeg")
+		saveTestImage(t, imageStore, "laptop", "../tmp/macbook.png")
+
+		// the file of the previous type is removed (and kept as a version if history is kept)
+		require.NoFileExists(t, filepath.Join(testImageFolder, "laptop.jpeg"))
+		require.FileExists(t, filepath.Join(testImageFolder, "laptop.png"))
+
+		report, err := imageStore.CheckConsistency()
+		require.NoError(t, err)
+		require.True(t, report.Consistent(), "%+v", report)
+
+		imageData, err := os.ReadFile("../tmp/macbook.png")
+		require.NoError(t, err)
+		require.Equal(t, imageData, downloadTestImage(t, imageStore, "laptop"))
+	}
+}
+
+func TestDiskImageStoreReportsInconsistentFiles(t *testing.T) {
+	t.Parallel()
+
+	testImageFolder := t.TempDir()
+	imageStore, err := service.NewDiskImageStore(testImageFolder)
+	require.NoError(t, err)
+
+	_, err = imageStore.Save(&service.ImageInfo{ImageName: "noise", Type: ".png"}, bytes.NewReader(randomPNG(t, 8, 8)))
+	require.NoError(t, err)
+	_, err = imageStore.Save(&service.ImageInfo{ImageName: "noise", Type: ".png"}, bytes.NewReader(randomPNG(t, 8, 8)))
+	require.NoError(t, err)
+	saveTestImage(t, imageStore, "laptop", "../tmp/laptop.jpeg")
+
+	report, err := imageStore.CheckConsistency()
+	require.NoError(t, err)
+	require.True(t, report.Consistent(), "%+v", report)
+
+	// files appeared or disappeared behind the store back are reported
+	copyTestFile(t, "../tmp/macbook.png", filepath.Join(testImageFolder, "noise.jpeg"))
+	require.NoError(t, os.WriteFile(filepath.Join(testImageFolder, ".versions", "noise", "notes.txt"), []byte("notes"), 0644))
+	require.NoError(t, os.Remove(filepath.Join(testImageFolder, "laptop.jpeg")))
+
+	report, err = imageStore.CheckConsistency()
+	require.NoError(t, err)
+	require.False(t, report.Consistent())
+	require.Equal(t, []string{filepath.Join(".versions", "noise", "notes.txt"), "noise.jpeg"}, report.UnreferencedFiles)
+	require.Equal(t, []string{"laptop.jpeg"}, report.MissingFiles)
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"os"
+	"strings"
+
+	// register decoders of supported image formats
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maximum number of bytes read to detect image format and dimensions
+// (jpeg headers may be preceded by large EXIF segments)
+const maxImageHeaderSize = 1 << 20
+
+// image format (as registered in the image package) of each allowed image type
+var imageTypeFormats = map[string]string{
+	".jpeg": "jpeg",
+	".jpg":  "jpeg",
+	".png":  "png",
+	".gif":  "gif",
+	".bmp":  "bmp",
+	".webp": "webp",
+}
+
+// sniffImage checks that image data starts with a decodable image header of the format matching image type.
+// It returns image config, detected format and a reader of the whole image data (including the sniffed header).
+// Mismatches are reported as InvalidArgument errors.
+func sniffImage(imageData io.Reader, imageType string) (image.Config, string, io.Reader, error) {
+	// remember bytes read by the decoder to replay them later
+	header := bytes.Buffer{}
+	headerReader := io.TeeReader(io.LimitReader(imageData, maxImageHeaderSize), &header)
+
+	// trying to decode image header
+	config, format, err := image.DecodeConfig(headerReader)
+	if err != nil {
+		return image.Config{}, "", nil, status.Errorf(codes.InvalidArgument, "image data is not a supported image: %v", err)
+	}
+
+	// check that detected format matches declared image type
+	if expectedFormat := imageTypeFormats[strings.ToLower(imageType)]; format != expectedFormat {
+		return image.Config{}, "", nil, status.Errorf(codes.InvalidArgument, "image data is %s, but image type is %s", format, imageType)
+	}
+
+	return config, format, io.MultiReader(&header, imageData), nil
+}
+
+// readImageProperties decodes header of the image file lying at the path and fills image properties of image info
+func readImageProperties(imageInfo *ImageInfo, path string) error {
+	// trying to open image file
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// trying to decode image header
+	config, format, _, err := sniffImage(file, imageInfo.Type)
+	if err != nil {
+		return err
+	}
+
+	imageInfo.Width = config.Width
+	imageInfo.Height = config.Height
+	imageInfo.ColorModel = colorModelName(config.ColorModel)
+	imageInfo.Format = format
+
+	return nil
+}
+
+// colorModelName returns name of the color model of decoded image config
+func colorModelName(model color.Model) string {
+	if _, ok := model.(color.Palette); ok {
+		return "Paletted"
+	}
+
+	switch model {
+	case color.RGBAModel:
+		return "RGBA"
+	case color.RGBA64Model:
+		return "RGBA64"
+	case color.NRGBAModel:
+		return "NRGBA"
+	case color.NRGBA64Model:
+		return "NRGBA64"
+	case color.AlphaModel:
+		return "Alpha"
+	case color.Alpha16Model:
+		return "Alpha16"
+	case color.GrayModel:
+		return "Gray"
+	case color.Gray16Model:
+		return "Gray16"
+	case color.CMYKModel:
+		return "CMYK"
+	case color.YCbCrModel:
+		return "YCbCr"
+	case color.NYCbCrAModel:
+		return "NYCbCrA"
+	default:
+		return "Unknown"
+	}
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// expired reports if the image is expired by the time
+func (imageInfo *ImageInfo) expired(now time.Time) bool {
+	return !imageInfo.ExpiresAt.IsZero() && !now.Before(imageInfo.ExpiresAt)
+}
+
+// liveImage returns info of the image if it exists and isn't expired yet, expired images are treated as missing
+// even before they are evicted (caller must hold the store lock)
+func (store *DiskImageStore) liveImage(imageName string) *ImageInfo {
+	imageInfo := store.images[imageName]
+	if imageInfo == nil || imageInfo.expired(store.now()) {
+		return nil
+	}
+	return imageInfo
+}
+
+// EvictExpired permanently removes files and info of images expired by the time with their previous versions
+func (store *DiskImageStore) EvictExpired(now time.Time) (int, error) {
+	// take names of expired images
+	store.mutex.RLock()
+	imageNames := make([]string, 0)
+	for imageName, imageInfo := range store.images {
+		if imageInfo.expired(now) {
+			imageNames = append(imageNames, imageName)
+		}
+	}
+	store.mutex.RUnlock()
+
+	evicted := 0
+	for _, imageName := range imageNames {
+		// trying to remove the image if it is still expired under the lock (it may be uploaded again meanwhile)
+		removed, err := store.evictImage(imageName, now)
+		if err != nil {
+			return evicted, err
+		}
+		if removed {
+			log.Printf("evicted expired image %s", imageName)
+			evicted++
+		}
+	}
+
+	return evicted, nil
+}
+
+// evictImage removes the image with its previous versions if it is expired by the time and reports if it was removed
+func (store *DiskImageStore) evictImage(imageName string, now time.Time) (bool, error) {
+	// lock image for writing
+	unlock := store.imageLocks.Lock(imageName)
+	defer unlock()
+
+	return store.evictLockedImage(imageName, now)
+}
+
+// evictLockedImage removes the image with its previous versions if it is expired by the time and reports if it was removed
+// (caller must hold the image lock). The file is moved aside first and removed only after the index without the image is persisted.
+func (store *DiskImageStore) evictLockedImage(imageName string, now time.Time) (bool, error) {
+	// get the image with its history (it can't be changed by others while the image is locked)
+	store.mutex.RLock()
+	imageInfo := store.images[imageName]
+	versions := store.versions[imageName]
+	store.mutex.RUnlock()
+
+	// check that image is still there and expired
+	if imageInfo == nil || !imageInfo.expired(now) {
+		return false, nil
+	}
+
+	// trying to move image file aside (hidden files are ignored by folder scanning)
+	imagePath := filepath.Join(store.imageFolder, imageName+imageInfo.Type)
+	evictedImagePath := filepath.Join(store.imageFolder, deletedFilePrefix+imageName+imageInfo.Type)
+	fileMoved := true
+	err := os.Rename(imagePath, evictedImagePath)
+	if errors.Is(err, os.ErrNotExist) {
+		fileMoved = false
+	} else if err != nil {
+		return false, fmt.Errorf("cannot move image file: %w", err)
+	}
+
+	// trying to remove the image with its history and persist the index, restore the image on failure
+	err = store.commitIndex(func() {
+		delete(store.images, imageName)
+		delete(store.versions, imageName)
+	}, func() {
+		store.images[imageName] = imageInfo
+		if versions != nil {
+			store.versions[imageName] = versions
+		}
+	})
+	if err != nil {
+		if fileMoved {
+			os.Rename(evictedImagePath, imagePath)
+		}
+		return false, err
+	}
+	store.releaseImageBlobs(imageInfo, versions)
+
+	// trying to remove files of previous versions
+	err = os.RemoveAll(versionFolderPath(store.imageFolder, imageName))
+	if err != nil {
+		log.Printf("cannot remove versions of evicted image %s: %v", imageName, err)
+	}
+
+	// trying to remove image file
+	if fileMoved {
+		err = os.Remove(evictedImagePath)
+		if err != nil {
+			log.Printf("cannot remove evicted image file %s: %v", evictedImagePath, err)
+		}
+	}
+
+	return true, nil
+}
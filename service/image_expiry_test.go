@@ -0,0 +1,136 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MrPark97/tages/pb"
+	"github.com/MrPark97/tages/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestImageStoresExpireByStoreClock(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{}
+	clock.Set(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	diskStore, err := service.NewDiskImageStore(t.TempDir(), service.WithClock(clock.Now))
+	require.NoError(t, err)
+	memoryStore := service.NewMemoryImageStore(service.WithMemoryClock(clock.Now))
+
+	for _, imageStore := range []service.ImageStore{diskStore, memoryStore} {
+		_, err = imageStore.Save(&service.ImageInfo{ImageName: "preview", Type: ".png", TimeToLive: time.Hour}, bytes.NewReader(randomPNG(t, 8, 8)))
+		require.NoError(t, err)
+	}
+
+	// the image expires when the store clock passes its expiration time, not the wall clock
+	for _, imageStore := range []service.ImageStore{diskStore, memoryStore} {
+		_, err = imageStore.Find("preview")
+		require.NoError(t, err)
+	}
+	clock.Set(time.Date(2023, 1, 1, 2, 0, 0, 0, time.UTC))
+	for _, imageStore := range []service.ImageStore{diskStore, memoryStore} {
+		_, err = imageStore.Find("preview")
+		require.Equal(t, codes.NotFound, status.Code(err))
+		require.Empty(t, imageStore.List(service.ListOptions{}))
+
+		// the next save starts a new image
+		imageInfo := &service.ImageInfo{ImageName: "preview", Type: ".png"}
+		_, err = imageStore.Save(imageInfo, bytes.NewReader(randomPNG(t, 8, 8)))
+		require.NoError(t, err)
+		require.Equal(t, int64(1), imageInfo.Version)
+	}
+}
+
+func TestDiskImageStoreExpiresImages(t *testing.T) {
+	t.Parallel()
+
+	testImageFolder := t.TempDir()
+	imageStore, err := service.NewDiskImageStore(testImageFolder)
+	require.NoError(t, err)
+
+	// save an already expired image (the second save replaces it) and an image expiring later
+	for i := 0; i < 2; i++ {
+		_, err = imageStore.Save(&service.ImageInfo{ImageName: "expired", Type: ".png", ExpiresAt: time.Now().Add(-time.Second)}, bytes.NewReader(randomPNG(t, 8, 8)))
+		require.NoError(t, err)
+	}
+	expiresAt := time.Now().Add(time.Hour)
+	_, err = imageStore.Save(&service.ImageInfo{ImageName: "preview", Type: ".png", ExpiresAt: expiresAt}, bytes.NewReader(randomPNG(t, 8, 8)))
+	require.NoError(t, err)
+
+	// expired image is hidden before it is evicted
+	_, err = imageStore.Find("expired")
+	require.Equal(t, codes.NotFound, status.Code(err))
+	err = imageStore.Send(newTestDownloadStream(), "expired", service.SendOptions{}, func(chunkData []byte) error {
+		return nil
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	images := imageStore.List(service.ListOptions{})
+	require.Len(t, images, 1)
+	require.Equal(t, "preview", images[0].ImageName)
+	require.True(t, expiresAt.Equal(images[0].ExpiresAt))
+
+	// the janitor evicts images expired by the time it runs
+	janitor := service.NewJanitor(imageStore)
+	janitor.Collect(time.Now())
+	require.NoFileExists(t, testImageFolder+"/expired.png")
+	require.NoDirExists(t, testImageFolder+"/.versions/expired")
+	require.FileExists(t, testImageFolder+"/preview.png")
+
+	janitor.Collect(time.Now().Add(2 * time.Hour))
+	require.NoFileExists(t, testImageFolder+"/preview.png")
+
+	// eviction survives the store restart
+	imageStore, err = service.NewDiskImageStore(testImageFolder)
+	require.NoError(t, err)
+	require.Empty(t, imageStore.List(service.ListOptions{}))
+	require.Empty(t, imageStore.ListTrash())
+}
+
+func TestClientUploadImageWithExpiry(t *testing.T) {
+	t.Parallel()
+
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	serverAddress := startTestImageServer(t, imageStore)
+	imageClient := newTestImageClient(t, serverAddress)
+
+	imageData := randomPNG(t, 8, 8)
+	uploadTime := time.Now()
+	_, err = uploadTestImage(t, imageClient, &pb.Info{Name: "preview", Type: ".png", Ttl: durationpb.New(24 * time.Hour)}, imageData)
+	require.NoError(t, err)
+
+	infoRes, err := imageClient.GetImageInfo(context.Background(), &pb.GetImageInfoRequest{Name: "preview"})
+	require.NoError(t, err)
+	expiresAt := infoRes.GetInfo().GetExpiresAt().AsTime()
+	require.False(t, expiresAt.Before(uploadTime.Add(24*time.Hour)))
+	require.True(t, expiresAt.Before(time.Now().Add(24*time.Hour)))
+
+	// the next upload replaces expiration time
+	_, err = uploadTestImage(t, imageClient, &pb.Info{Name: "preview", Type: ".png"}, imageData)
+	require.NoError(t, err)
+	infoRes, err = imageClient.GetImageInfo(context.Background(), &pb.GetImageInfoRequest{Name: "preview"})
+	require.NoError(t, err)
+	require.Nil(t, infoRes.GetInfo().GetExpiresAt())
+
+	testCases := []struct {
+		name string
+		info *pb.Info
+	}{
+		{"both expires_at and ttl", &pb.Info{Name: "preview", Type: ".png", ExpiresAt: timestamppb.New(time.Now().Add(time.Hour)), Ttl: durationpb.New(time.Hour)}},
+		{"expires_at in the past", &pb.Info{Name: "preview", Type: ".png", ExpiresAt: timestamppb.New(time.Now().Add(-time.Hour))}},
+		{"negative ttl", &pb.Info{Name: "preview", Type: ".png", Ttl: durationpb.New(-time.Hour)}},
+	}
+
+	for _, tc := range testCases {
+		_, err = uploadTestImage(t, imageClient, tc.info, imageData)
+		require.Equal(t, codes.InvalidArgument, status.Code(err), tc.name)
+	}
+}
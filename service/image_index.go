@@ -0,0 +1,177 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// name of the index file kept in the image folder
+const imageIndexFileName = ".index.json"
+
+// imageIndex is the on-disk representation of the store catalog
+type imageIndex struct {
+	Images map[string]*ImageInfo `json:"images"`
+	// unfinished upload sessions
+	Sessions map[string]*UploadSession `json:"sessions,omitempty"`
+	// previous versions of images from the oldest one
+	Versions map[string][]*ImageInfo `json:"versions,omitempty"`
+	// deleted images kept in the trash
+	Trash map[string]*TrashItem `json:"trash,omitempty"`
+}
+
+// loadImageIndex reads images info, upload sessions, previous versions and trash items from the index file in image folder (missing index means empty store)
+func loadImageIndex(imageFolder string) (*imageIndex, error) {
+	images := make(map[string]*ImageInfo)
+	sessions := make(map[string]*UploadSession)
+	versions := make(map[string][]*ImageInfo)
+	trash := make(map[string]*TrashItem)
+
+	// trying to read index file
+	data, err := os.ReadFile(filepath.Join(imageFolder, imageIndexFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return &imageIndex{Images: images, Sessions: sessions, Versions: versions, Trash: trash}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read image index: %w", err)
+	}
+
+	// trying to decode index
+	index := imageIndex{}
+	err = json.Unmarshal(data, &index)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode image index: %w", err)
+	}
+
+	for imageName, imageInfo := range index.Images {
+		if imageInfo != nil {
+			images[imageName] = imageInfo
+		}
+	}
+	for sessionID, session := range index.Sessions {
+		if session != nil && session.ImageInfo != nil {
+			sessions[sessionID] = session
+		}
+	}
+	for imageName, imageVersions := range index.Versions {
+		if len(imageVersions) > 0 {
+			versions[imageName] = imageVersions
+		}
+	}
+	for trashID, item := range index.Trash {
+		if item != nil && item.ImageInfo != nil {
+			trash[trashID] = item
+		}
+	}
+
+	return &imageIndex{Images: images, Sessions: sessions, Versions: versions, Trash: trash}, nil
+}
+
+// saveImageIndex writes images info, upload sessions, previous versions and trash items to the index file in image folder
+func saveImageIndex(imageFolder string, index *imageIndex) error {
+	data, err := encodeImageIndex(index)
+	if err != nil {
+		return err
+	}
+
+	return writeImageIndex(imageFolder, data)
+}
+
+// encodeImageIndex encodes images info, upload sessions, previous versions and trash items
+func encodeImageIndex(index *imageIndex) ([]byte, error) {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode image index: %w", err)
+	}
+	return data, nil
+}
+
+// writeImageIndex writes the encoded index to the index file in image folder.
+// The index is written to a temporary file first and then atomically renamed,
+// so a crash in the middle of writing never leaves a half-written index behind.
+func writeImageIndex(imageFolder string, data []byte) error {
+	// trying to create temporary file next to the index
+	file, err := os.CreateTemp(imageFolder, imageIndexFileName+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("cannot create temporary image index: %w", err)
+	}
+	tmpPath := file.Name()
+
+	// trying to write and flush data to disk
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("cannot write temporary image index: %w", err)
+	}
+
+	// trying to replace the index with the new one
+	err = os.Rename(tmpPath, filepath.Join(imageFolder, imageIndexFileName))
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("cannot replace image index: %w", err)
+	}
+
+	// flush the rename itself (not supported on every platform, so errors are ignored)
+	syncDir(imageFolder)
+
+	return nil
+}
+
+// copy returns a copy of the index with copies of its entries, so it can be encoded without holding the store lock
+func (index *imageIndex) copy() *imageIndex {
+	indexCopy := &imageIndex{
+		Images:   make(map[string]*ImageInfo, len(index.Images)),
+		Sessions: make(map[string]*UploadSession, len(index.Sessions)),
+		Versions: make(map[string][]*ImageInfo, len(index.Versions)),
+		Trash:    make(map[string]*TrashItem, len(index.Trash)),
+	}
+	for imageName, imageInfo := range index.Images {
+		imageInfoCopy := *imageInfo
+		indexCopy.Images[imageName] = &imageInfoCopy
+	}
+	for sessionID, session := range index.Sessions {
+		indexCopy.Sessions[sessionID] = copySession(session)
+	}
+	for imageName, versions := range index.Versions {
+		indexCopy.Versions[imageName] = copyImages(versions)
+	}
+	for trashID, item := range index.Trash {
+		itemCopy := *item
+		imageInfoCopy := *item.ImageInfo
+		itemCopy.ImageInfo = &imageInfoCopy
+		itemCopy.Versions = copyImages(item.Versions)
+		indexCopy.Trash[trashID] = &itemCopy
+	}
+	return indexCopy
+}
+
+// function to copy images info
+func copyImages(images []*ImageInfo) []*ImageInfo {
+	if images == nil {
+		return nil
+	}
+	imagesCopy := make([]*ImageInfo, len(images))
+	for i, imageInfo := range images {
+		imageInfoCopy := *imageInfo
+		imagesCopy[i] = &imageInfoCopy
+	}
+	return imagesCopy
+}
+
+// syncDir flushes directory entries of the folder to disk
+func syncDir(folder string) {
+	dir, err := os.Open(folder)
+	if err != nil {
+		return
+	}
+	defer dir.Close()
+	dir.Sync()
+}
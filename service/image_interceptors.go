@@ -16,16 +16,17 @@ func UnaryServerInterceptor(ctx context.Context,
 	// parse method name
 	methodName := strings.Split(info.FullMethod, "/")[2]
 	// write to the channel (blocking if reaching limit)
-	if methodName == "GetUploadedImagesTableString" {
-		info.Server.(*ImageServer).GetUploadedImagesTableStringLimitChannel <- struct{}{}
+	limitChannel := info.Server.(*ImageServer).limitChannel(methodName)
+	if limitChannel != nil {
+		limitChannel <- struct{}{}
 	}
 
 	// calls the handler
 	h, err := handler(ctx, req)
 
 	// read from the channel (freeing one spot)
-	if methodName == "GetUploadedImagesTableString" {
-		<-info.Server.(*ImageServer).GetUploadedImagesTableStringLimitChannel
+	if limitChannel != nil {
+		<-limitChannel
 	}
 
 	return h, err
@@ -37,20 +38,17 @@ func StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.S
 	// parse method name
 	methodName := strings.Split(info.FullMethod, "/")[2]
 	// write to the channel (blocking if reaching limit)
-	if methodName == "DownloadImage" {
-		srv.(*ImageServer).DownloadImageLimitChannel <- struct{}{}
-	} else if methodName == "UploadImage" {
-		srv.(*ImageServer).UploadImageLimitChannel <- struct{}{}
+	limitChannel := srv.(*ImageServer).limitChannel(methodName)
+	if limitChannel != nil {
+		limitChannel <- struct{}{}
 	}
 
 	// calls the handler
 	err := handler(srv, ss)
 
 	// read from the channel (freeing one spot)
-	if methodName == "DownloadImage" {
-		<-srv.(*ImageServer).DownloadImageLimitChannel
-	} else if methodName == "UploadImage" {
-		<-srv.(*ImageServer).UploadImageLimitChannel
+	if limitChannel != nil {
+		<-limitChannel
 	}
 
 	return err
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Janitor periodically removes garbage from the image store (e.g. expired images, abandoned upload sessions and expired trash)
+type Janitor struct {
+	imageStore ImageStore
+	// period of the housekeeping runs
+	Interval time.Duration
+	// upload sessions without appends for longer than the TTL are removed
+	UploadSessionTTL time.Duration
+	// deleted images are purged from the trash after the retention
+	TrashRetention time.Duration
+}
+
+// NewJanitor returns a new Janitor of the image store with default settings
+func NewJanitor(imageStore ImageStore) *Janitor {
+	return &Janitor{
+		imageStore:       imageStore,
+		Interval:         time.Minute,
+		UploadSessionTTL: 24 * time.Hour,
+		TrashRetention:   7 * 24 * time.Hour,
+	}
+}
+
+// Run performs housekeeping every interval until the context is done
+func (janitor *Janitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(janitor.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			janitor.Collect(now)
+		}
+	}
+}
+
+// Collect performs housekeeping once as if it is the time now
+func (janitor *Janitor) Collect(now time.Time) {
+	// trying to evict expired images
+	evicted, err := janitor.imageStore.EvictExpired(now)
+	if err != nil {
+		log.Printf("cannot evict expired images: %v", err)
+	}
+	if evicted > 0 {
+		log.Printf("evicted %d expired images", evicted)
+	}
+
+	// trying to remove abandoned upload sessions
+	collected, err := janitor.imageStore.CollectUploadSessions(now.Add(-janitor.UploadSessionTTL))
+	if err != nil {
+		log.Printf("cannot collect upload sessions: %v", err)
+	}
+	if collected > 0 {
+		log.Printf("collected %d abandoned upload sessions", collected)
+	}
+
+	// trying to purge images deleted long ago
+	purged, err := janitor.imageStore.PurgeTrash(now.Add(-janitor.TrashRetention))
+	if err != nil {
+		log.Printf("cannot purge trash: %v", err)
+	}
+	if purged > 0 {
+		log.Printf("purged %d deleted images from the trash", purged)
+	}
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MrPark97/tages/pb"
+)
+
+// ListOptions describes which images are listed and in which order
+type ListOptions struct {
+	SortField pb.SortField
+	SortOrder pb.SortOrder
+	// only images with names starting with the prefix are listed
+	NamePrefix string
+	// only images of the type (with dot, case insensitive) are listed
+	Type string
+	// only images created in [CreatedAfter, CreatedBefore) are listed (zero time means no bound)
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// only images updated in [UpdatedAfter, UpdatedBefore) are listed (zero time means no bound)
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+}
+
+// listImages returns copies of images info matching the options in the requested order,
+// images expired by the time are skipped (caller must hold the lock of the images)
+func listImages(images map[string]*ImageInfo, options ListOptions, now time.Time) []*ImageInfo {
+	// copy matching images info so callers can't race with the store
+	matched := make([]*ImageInfo, 0, len(images))
+	for _, imageInfo := range images {
+		if !imageInfo.expired(now) && options.match(imageInfo) {
+			imageInfoCopy := *imageInfo
+			matched = append(matched, &imageInfoCopy)
+		}
+	}
+
+	sortImages(matched, options.SortField, options.SortOrder)
+
+	return matched
+}
+
+// match checks if image satisfies list options filters
+func (options ListOptions) match(imageInfo *ImageInfo) bool {
+	if !strings.HasPrefix(imageInfo.ImageName, options.NamePrefix) {
+		return false
+	}
+	if options.Type != "" && !strings.EqualFold(imageInfo.Type, options.Type) {
+		return false
+	}
+	return inTimeRange(imageInfo.CreatedAt, options.CreatedAfter, options.CreatedBefore) &&
+		inTimeRange(imageInfo.UpdatedAt, options.UpdatedAfter, options.UpdatedBefore)
+}
+
+// filtersHash returns hex encoded SHA-256 of the filters of list options (sort field and order aren't included)
+func (options ListOptions) filtersHash() string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%q\n%q\n", options.NamePrefix, strings.ToLower(options.Type))
+	for _, t := range []time.Time{options.CreatedAfter, options.CreatedBefore, options.UpdatedAfter, options.UpdatedBefore} {
+		fmt.Fprintf(hash, "%s\n", t.UTC().Format(time.RFC3339Nano))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// function to check if time is in [after, before) range (zero bounds are ignored)
+func inTimeRange(t time.Time, after time.Time, before time.Time) bool {
+	if !after.IsZero() && t.Before(after) {
+		return false
+	}
+	if !before.IsZero() && !t.Before(before) {
+		return false
+	}
+	return true
+}
+
+// sortImages sorts images by the field in the order, images with equal field values are sorted by name
+func sortImages(images []*ImageInfo, sortField pb.SortField, sortOrder pb.SortOrder) {
+	sort.Slice(images, func(i, j int) bool {
+		return imageBefore(images[i], images[j], sortField, sortOrder)
+	})
+}
+
+// imageBefore checks if image a goes before image b sorted by the field in the order
+// (images with equal field values are ordered by name, so the order is total)
+func imageBefore(a *ImageInfo, b *ImageInfo, sortField pb.SortField, sortOrder pb.SortOrder) bool {
+	if sortOrder == pb.SortOrder_SORT_ORDER_DESC {
+		a, b = b, a
+	}
+
+	switch sortField {
+	case pb.SortField_SORT_FIELD_CREATED_AT:
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+	case pb.SortField_SORT_FIELD_UPDATED_AT:
+		if !a.UpdatedAt.Equal(b.UpdatedAt) {
+			return a.UpdatedAt.Before(b.UpdatedAt)
+		}
+	case pb.SortField_SORT_FIELD_SIZE:
+		if a.Size != b.Size {
+			return a.Size < b.Size
+		}
+	}
+
+	return a.ImageName < b.ImageName
+}
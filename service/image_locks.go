@@ -0,0 +1,68 @@
+package service
+
+import "sync"
+
+// imageLocks is a set of per-image reader/writer locks created on demand
+// and dropped as soon as nobody holds or waits for them
+type imageLocks struct {
+	mutex sync.Mutex
+	locks map[string]*imageLock
+}
+
+// imageLock is a reader/writer lock of a single image with the number of its users
+type imageLock struct {
+	sync.RWMutex
+	refs int
+}
+
+// Lock locks the image for writing and returns the function unlocking it
+func (locks *imageLocks) Lock(imageName string) func() {
+	lock := locks.acquire(imageName)
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+		locks.release(imageName, lock)
+	}
+}
+
+// RLock locks the image for reading and returns the function unlocking it
+func (locks *imageLocks) RLock(imageName string) func() {
+	lock := locks.acquire(imageName)
+	lock.RLock()
+
+	return func() {
+		lock.RUnlock()
+		locks.release(imageName, lock)
+	}
+}
+
+// acquire returns the lock of the image (creating it if needed) and counts one more user of it
+func (locks *imageLocks) acquire(imageName string) *imageLock {
+	locks.mutex.Lock()
+	defer locks.mutex.Unlock()
+
+	if locks.locks == nil {
+		locks.locks = make(map[string]*imageLock)
+	}
+
+	lock := locks.locks[imageName]
+	if lock == nil {
+		lock = &imageLock{}
+		locks.locks[imageName] = lock
+	}
+	lock.refs++
+
+	return lock
+}
+
+// release counts one user of the image lock less and drops the lock if it is not used anymore
+func (locks *imageLocks) release(imageName string, lock *imageLock) {
+	locks.mutex.Lock()
+	defer locks.mutex.Unlock()
+
+	lock.refs--
+	if lock.refs == 0 {
+		delete(locks.locks, imageName)
+	}
+}
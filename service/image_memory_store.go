@@ -0,0 +1,747 @@
+package service
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/MrPark97/tages/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MemoryImageStore is a struct to store images in memory (e.g. for tests and scratch environments).
+// Content is kept by checksum, so identical content is stored once, and never modified in place,
+// so it is streamed without holding any lock.
+// If the byte budget is set, stored content is kept within it: expired images are evicted first,
+// then the oldest deleted images and then least recently used images (data of upload sessions isn't counted).
+type MemoryImageStore struct {
+	mutex        sync.RWMutex
+	sessionLocks imageLocks
+	images       map[string]*ImageInfo
+	sessions     map[string]*memoryUploadSession
+	// previous versions of images from the oldest one
+	versions map[string][]*ImageInfo
+	// number of previous versions kept for every image
+	versionRetention int
+	// deleted images kept in the trash
+	trash map[string]*TrashItem
+	// content of images by checksum
+	blobs map[string]*memoryBlob
+	// total size of stored content and maximal one (zero means no limit)
+	size   int64
+	budget int64
+	// names of images from the most recently used one
+	recentlyUsed *list.List
+	elements     map[string]*list.Element
+	// clock stamping creation and update time of saved images and telling if images are expired
+	now func() time.Time
+}
+
+// MemoryImageStoreOption is a function to configure MemoryImageStore
+type MemoryImageStoreOption func(store *MemoryImageStore)
+
+// memoryBlob is a struct to operate content shared by images, previous versions and trash items with identical checksum
+type memoryBlob struct {
+	data []byte
+	// number of references from the catalog
+	refs int
+}
+
+// memoryUploadSession is a struct to operate state of a resumable upload with data committed to it
+type memoryUploadSession struct {
+	session *UploadSession
+	data    []byte
+}
+
+// WithMemoryBudget sets maximal total size of content kept by the store in bytes (zero means no limit)
+func WithMemoryBudget(bytes int64) MemoryImageStoreOption {
+	return func(store *MemoryImageStore) {
+		if bytes < 0 {
+			bytes = 0
+		}
+		store.budget = bytes
+	}
+}
+
+// WithMemoryVersionRetention sets number of previous versions kept for every image (zero disables history)
+func WithMemoryVersionRetention(count int) MemoryImageStoreOption {
+	return func(store *MemoryImageStore) {
+		if count < 0 {
+			count = 0
+		}
+		store.versionRetention = count
+	}
+}
+
+// WithMemoryClock sets the clock stamping creation and update time of saved images and telling if images are expired (e.g. a fixed one for tests)
+func WithMemoryClock(now func() time.Time) MemoryImageStoreOption {
+	return func(store *MemoryImageStore) {
+		if now != nil {
+			store.now = now
+		}
+	}
+}
+
+// NewMemoryImageStore returns a new empty MemoryImageStore
+func NewMemoryImageStore(options ...MemoryImageStoreOption) *MemoryImageStore {
+	store := &MemoryImageStore{
+		images:           make(map[string]*ImageInfo),
+		sessions:         make(map[string]*memoryUploadSession),
+		versions:         make(map[string][]*ImageInfo),
+		versionRetention: defaultVersionRetention,
+		trash:            make(map[string]*TrashItem),
+		blobs:            make(map[string]*memoryBlob),
+		recentlyUsed:     list.New(),
+		elements:         make(map[string]*list.Element),
+		now:              time.Now,
+	}
+	for _, option := range options {
+		option(store)
+	}
+
+	return store
+}
+
+// liveImage returns info of the image if it exists and isn't expired yet (caller must hold the store lock)
+func (store *MemoryImageStore) liveImage(imageName string) *ImageInfo {
+	imageInfo := store.images[imageName]
+	if imageInfo == nil || imageInfo.expired(store.now()) {
+		return nil
+	}
+	return imageInfo
+}
+
+// touch marks the image as the most recently used one (caller must hold the store lock)
+func (store *MemoryImageStore) touch(imageName string) {
+	if element := store.elements[imageName]; element != nil {
+		store.recentlyUsed.MoveToFront(element)
+		return
+	}
+	store.elements[imageName] = store.recentlyUsed.PushFront(imageName)
+}
+
+// forget removes the image from the recently used ones (caller must hold the store lock)
+func (store *MemoryImageStore) forget(imageName string) {
+	if element := store.elements[imageName]; element != nil {
+		store.recentlyUsed.Remove(element)
+		delete(store.elements, imageName)
+	}
+}
+
+// acquireBlob adds a reference to the blob with the content (caller must hold the store lock)
+func (store *MemoryImageStore) acquireBlob(checksum string, data []byte) {
+	b := store.blobs[checksum]
+	if b == nil {
+		b = &memoryBlob{data: data}
+		store.blobs[checksum] = b
+		store.size += int64(len(data))
+	}
+	b.refs++
+}
+
+// releaseBlob removes a reference to the blob and drops the content with the last one (caller must hold the store lock)
+func (store *MemoryImageStore) releaseBlob(checksum string) {
+	b := store.blobs[checksum]
+	if b == nil {
+		return
+	}
+
+	b.refs--
+	if b.refs > 0 {
+		return
+	}
+
+	delete(store.blobs, checksum)
+	store.size -= int64(len(b.data))
+}
+
+// releaseImageBlobs removes references of the image and its previous versions (caller must hold the store lock)
+func (store *MemoryImageStore) releaseImageBlobs(imageInfo *ImageInfo, versions []*ImageInfo) {
+	store.releaseBlob(imageInfo.Checksum)
+	for _, versionInfo := range versions {
+		store.releaseBlob(versionInfo.Checksum)
+	}
+}
+
+// removeImage removes the image with its previous versions and releases their content (caller must hold the store lock)
+func (store *MemoryImageStore) removeImage(imageName string) {
+	imageInfo := store.images[imageName]
+	if imageInfo == nil {
+		return
+	}
+
+	store.releaseImageBlobs(imageInfo, store.versions[imageName])
+	delete(store.images, imageName)
+	delete(store.versions, imageName)
+	store.forget(imageName)
+}
+
+// reserve evicts images until the content of the size fits the budget together with stored content,
+// the image with the name isn't evicted (caller must hold the store lock).
+// It returns ResourceExhausted error if the content doesn't fit even after eviction.
+func (store *MemoryImageStore) reserve(imageName string, size int64) error {
+	if store.budget == 0 || store.size+size <= store.budget {
+		return nil
+	}
+	if size > store.budget {
+		return status.Errorf(codes.ResourceExhausted, "image of %d bytes exceeds memory budget of %d bytes", size, store.budget)
+	}
+
+	// evict expired images first
+	now := store.now()
+	for name, imageInfo := range store.images {
+		if name != imageName && imageInfo.expired(now) {
+			log.Printf("evicted expired image %s to fit memory budget", name)
+			store.removeImage(name)
+		}
+	}
+
+	// then purge the oldest deleted images
+	items := listTrashItems(store.trash)
+	for i := len(items) - 1; i >= 0 && store.size+size > store.budget; i-- {
+		item := store.trash[items[i].ID]
+		log.Printf("purged image %s deleted at %v to fit memory budget", item.ImageInfo.ImageName, item.DeletedAt)
+		delete(store.trash, item.ID)
+		store.releaseImageBlobs(item.ImageInfo, item.Versions)
+	}
+
+	// then evict the least recently used images
+	for element := store.recentlyUsed.Back(); element != nil && store.size+size > store.budget; {
+		name := element.Value.(string)
+		element = element.Prev()
+		if name == imageName {
+			continue
+		}
+		log.Printf("evicted least recently used image %s to fit memory budget", name)
+		store.removeImage(name)
+	}
+
+	if store.size+size > store.budget {
+		return status.Errorf(codes.ResourceExhausted, "image of %d bytes doesn't fit memory budget of %d bytes", size, store.budget)
+	}
+
+	return nil
+}
+
+// Save reads a new image into memory and then commits it under the lock,
+// so readers never see a partially received image.
+// Images are evicted to fit the budget before the commit.
+func (store *MemoryImageStore) Save(newImageInfo *ImageInfo, imageData io.Reader) (string, error) {
+	// trying to read image data (without locking the store) computing its checksum on the fly
+	hash := sha256.New()
+	data, err := io.ReadAll(io.TeeReader(imageData, hash))
+	if err != nil {
+		return "", fmt.Errorf("cannot read image data: %w", err)
+	}
+	imageSize := int64(len(data))
+
+	// check that received data matches declared checksum
+	imageChecksum := hex.EncodeToString(hash.Sum(nil))
+	if err := verifyChecksum(newImageInfo.Checksum, imageChecksum); err != nil {
+		return "", logError(err)
+	}
+
+	// lock in-memory storage
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// find name to save the image with according to the conflict policy
+	imageName, err := store.freeImageName(newImageInfo.ImageName, newImageInfo.ConflictPolicy)
+	if err != nil {
+		return "", logError(err)
+	}
+
+	// remove the expired image with the name, so the new image doesn't continue its history
+	if store.images[imageName] != nil && store.liveImage(imageName) == nil {
+		store.removeImage(imageName)
+	}
+
+	// check that the image wasn't changed since the client read it
+	previousImageInfo := store.images[imageName]
+	if err := verifyVersion(imageName, newImageInfo.ExpectedVersion, previousImageInfo); err != nil {
+		return "", logError(err)
+	}
+
+	// reference the content right away if it is stored already, so making room can't drop it meanwhile
+	stored := store.blobs[imageChecksum] != nil
+	required := imageSize
+	if stored {
+		store.acquireBlob(imageChecksum, data)
+		required = 0
+	}
+
+	// make room for the content unless it is stored already (content replaced without keeping a version is freed)
+	versioned := previousImageInfo != nil && store.versionRetention > 0
+	if previousImageInfo != nil && !versioned {
+		if b := store.blobs[previousImageInfo.Checksum]; b != nil && b.refs == 1 && previousImageInfo.Checksum != imageChecksum {
+			required -= int64(len(b.data))
+		}
+	}
+	if err := store.reserve(imageName, required); err != nil {
+		if stored {
+			store.releaseBlob(imageChecksum)
+		}
+		return "", logError(err)
+	}
+
+	// update in-memory storage value and history of the image
+	imageInfo := savedImageInfo(newImageInfo, imageName, imageSize, imageChecksum, previousImageInfo, store.now())
+	if !stored {
+		store.acquireBlob(imageChecksum, data)
+	}
+	store.images[imageName] = imageInfo
+	store.touch(imageName)
+	if versioned {
+		versionInfo := *previousImageInfo
+		var droppedVersions []*ImageInfo
+		store.versions[imageName], droppedVersions = appendVersion(store.versions[imageName], &versionInfo, store.versionRetention)
+		for _, versionInfo := range droppedVersions {
+			store.releaseBlob(versionInfo.Checksum)
+		}
+	} else if previousImageInfo != nil {
+		store.releaseBlob(previousImageInfo.Checksum)
+	}
+
+	// report fields set by the store back to the caller
+	*newImageInfo = *imageInfo
+
+	return imageName, nil
+}
+
+// freeImageName returns the name to save the image with according to the conflict policy (caller must hold the store lock)
+func (store *MemoryImageStore) freeImageName(imageName string, conflictPolicy pb.ConflictPolicy) (string, error) {
+	for i := 0; ; i++ {
+		// form candidate name (name, name-1, name-2, ...)
+		candidateName, err := candidateImageName(imageName, i)
+		if err != nil {
+			return "", err
+		}
+
+		exists := store.liveImage(candidateName) != nil
+		switch {
+		case !exists || conflictPolicy == pb.ConflictPolicy_CONFLICT_POLICY_OVERWRITE:
+			return candidateName, nil
+		case conflictPolicy == pb.ConflictPolicy_CONFLICT_POLICY_FAIL_IF_EXISTS:
+			return "", status.Errorf(codes.AlreadyExists, "image already exists: %v", imageName)
+		}
+	}
+}
+
+// Send sends image info first, then sends chunks of data of the requested range one by one via send function.
+// The store is locked only while the image is looked up.
+func (store *MemoryImageStore) Send(stream pb.ImageService_DownloadImageServer, imageName string, options SendOptions, send func(chunkData []byte) error) error {
+	ctx := stream.Context()
+
+	// check for context error
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	// trying to get the image version with its content
+	imageInfo, data, err := store.findVersion(imageName, options.Version)
+	if err != nil {
+		return err
+	}
+
+	return sendImage(stream, imageInfo, bytes.NewReader(data), options, send)
+}
+
+// findVersion returns a copy of info of the image version (zero means the current version) with its content
+// and marks the image as the most recently used one
+func (store *MemoryImageStore) findVersion(imageName string, version int64) (*ImageInfo, []byte, error) {
+	// lock in-memory storage
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// if no image with such name (or it is expired) return not found error
+	imageInfo := store.liveImage(imageName)
+	if imageInfo == nil {
+		return nil, nil, logError(status.Errorf(codes.NotFound, "image doesn't exists: %v", imageName))
+	}
+	store.touch(imageName)
+
+	// check the current version and look for the version in the history
+	versionInfo := imageInfo
+	if version != 0 && version != imageInfo.Version {
+		versionInfo = nil
+		for _, info := range store.versions[imageName] {
+			if info.Version == version {
+				versionInfo = info
+			}
+		}
+	}
+	if versionInfo == nil {
+		return nil, nil, logError(status.Errorf(codes.NotFound, "version %d of image %s doesn't exists", version, imageName))
+	}
+
+	versionInfoCopy := *versionInfo
+	return &versionInfoCopy, store.blobs[versionInfo.Checksum].data, nil
+}
+
+// String forms an uploaded images table string from the store.
+// Images are sorted by the field in the order first and then the limit is applied.
+func (store *MemoryImageStore) String(limit uint32, sortField pb.SortField, sortOrder pb.SortOrder) string {
+	// take sorted snapshot of images info
+	images := store.List(ListOptions{SortField: sortField, SortOrder: sortOrder})
+
+	return formatImagesTable(images, limit, store.Usage())
+}
+
+// List returns copies of images info matching the options in the requested order
+func (store *MemoryImageStore) List(options ListOptions) []*ImageInfo {
+	// lock in-memory storage for reading
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return listImages(store.images, options, store.now())
+}
+
+// Delete moves the image with its previous versions from the store to the trash
+func (store *MemoryImageStore) Delete(imageName string) error {
+	// trying to generate trash item identifier
+	trashID, err := newRandomID()
+	if err != nil {
+		return fmt.Errorf("cannot generate trash item id: %w", err)
+	}
+
+	// lock in-memory storage
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// if no image with such name (or it is expired) return not found error
+	imageInfo := store.liveImage(imageName)
+	if imageInfo == nil {
+		return logError(status.Errorf(codes.NotFound, "image doesn't exists: %v", imageName))
+	}
+
+	store.trash[trashID] = &TrashItem{
+		ID:        trashID,
+		ImageInfo: imageInfo,
+		Versions:  store.versions[imageName],
+		DeletedAt: time.Now(),
+	}
+	delete(store.images, imageName)
+	delete(store.versions, imageName)
+	store.forget(imageName)
+
+	return nil
+}
+
+// Find returns a copy of image info or not found error if there is no image with such name
+func (store *MemoryImageStore) Find(imageName string) (*ImageInfo, error) {
+	// lock in-memory storage for reading
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	// if no image with such name (or it is expired) return not found error
+	imageInfo := store.liveImage(imageName)
+	if imageInfo == nil {
+		return nil, logError(status.Errorf(codes.NotFound, "image doesn't exists: %v", imageName))
+	}
+
+	imageInfoCopy := *imageInfo
+	return &imageInfoCopy, nil
+}
+
+// CreateUploadSession creates a new upload session without data
+func (store *MemoryImageStore) CreateUploadSession(imageInfo *ImageInfo) (*UploadSession, error) {
+	// trying to generate session identifier
+	sessionID, err := newRandomID()
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate upload session id: %w", err)
+	}
+
+	// prepare session
+	now := time.Now()
+	session := &UploadSession{
+		ID:        sessionID,
+		ImageInfo: sessionImageInfo(imageInfo),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	// lock in-memory storage
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.sessions[sessionID] = &memoryUploadSession{session: session}
+
+	return copySession(session), nil
+}
+
+// AppendUploadSession appends data to the session chunk by chunk.
+// Appends to the same session are serialized, chunks read before a failure stay committed,
+// so the client can continue from the committed offset.
+func (store *MemoryImageStore) AppendUploadSession(sessionID string, offset int64, data io.Reader) (*UploadSession, error) {
+	// lock session for writing
+	unlock := store.sessionLocks.Lock(sessionID)
+	defer unlock()
+
+	// trying to get session state
+	session, err := store.FindUploadSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// check that data continues committed data
+	if offset != session.Offset {
+		return nil, logError(status.Errorf(codes.FailedPrecondition, "offset %d doesn't match committed offset %d of upload session %s", offset, session.Offset, sessionID))
+	}
+
+	// trying to read data chunk by chunk and commit every chunk
+	buffer := make([]byte, bufferSize)
+	for {
+		n, readErr := data.Read(buffer)
+		if n > 0 {
+			session, err = store.appendSessionData(sessionID, buffer[:n])
+			if err != nil {
+				return nil, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("cannot append data to upload session: %w", readErr)
+		}
+	}
+
+	return session, nil
+}
+
+// appendSessionData commits the chunk to the session and returns a copy of its state
+func (store *MemoryImageStore) appendSessionData(sessionID string, chunk []byte) (*UploadSession, error) {
+	// lock in-memory storage
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// if the session is removed meanwhile return not found error
+	memorySession := store.sessions[sessionID]
+	if memorySession == nil {
+		return nil, logError(status.Errorf(codes.NotFound, "upload session doesn't exists: %v", sessionID))
+	}
+
+	// appending never changes data committed before, so readers of it don't race with appends
+	memorySession.data = append(memorySession.data, chunk...)
+	memorySession.session.Offset = int64(len(memorySession.data))
+	memorySession.session.UpdatedAt = time.Now()
+
+	return copySession(memorySession.session), nil
+}
+
+// FindUploadSession returns a copy of upload session or not found error if there is no session with such identifier
+func (store *MemoryImageStore) FindUploadSession(sessionID string) (*UploadSession, error) {
+	session, _, err := store.findSession(sessionID)
+	return session, err
+}
+
+// findSession returns a copy of upload session with data committed to it
+func (store *MemoryImageStore) findSession(sessionID string) (*UploadSession, []byte, error) {
+	// lock in-memory storage for reading
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	// if no session with such identifier return not found error
+	memorySession := store.sessions[sessionID]
+	if memorySession == nil {
+		return nil, nil, logError(status.Errorf(codes.NotFound, "upload session doesn't exists: %v", sessionID))
+	}
+
+	return copySession(memorySession.session), memorySession.data, nil
+}
+
+// OpenUploadSession returns a copy of upload session and a reader of data committed to it by the moment of the call.
+// The session is marked as being finalized until the reader is closed, so it can't be opened again meanwhile.
+func (store *MemoryImageStore) OpenUploadSession(sessionID string) (*UploadSession, io.ReadCloser, error) {
+	// lock session for reading (waiting for running appends)
+	unlock := store.sessionLocks.RLock(sessionID)
+	defer unlock()
+
+	// lock in-memory storage
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// if no session with such identifier return not found error
+	memorySession := store.sessions[sessionID]
+	if memorySession == nil {
+		return nil, nil, logError(status.Errorf(codes.NotFound, "upload session doesn't exists: %v", sessionID))
+	}
+
+	// mark the session as being finalized until its data is closed
+	if memorySession.session.finalizing {
+		return nil, nil, logError(status.Errorf(codes.FailedPrecondition, "upload session %s is being finalized already", sessionID))
+	}
+	memorySession.session.finalizing = true
+	release := func() error {
+		store.mutex.Lock()
+		memorySession.session.finalizing = false
+		store.mutex.Unlock()
+		return nil
+	}
+
+	return copySession(memorySession.session), &sessionDataReader{Reader: bytes.NewReader(memorySession.data), close: release}, nil
+}
+
+// DeleteUploadSession removes upload session with its data
+func (store *MemoryImageStore) DeleteUploadSession(sessionID string) error {
+	// lock session for writing
+	unlock := store.sessionLocks.Lock(sessionID)
+	defer unlock()
+
+	// lock in-memory storage
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// if no session with such identifier return not found error
+	if store.sessions[sessionID] == nil {
+		return logError(status.Errorf(codes.NotFound, "upload session doesn't exists: %v", sessionID))
+	}
+	delete(store.sessions, sessionID)
+
+	return nil
+}
+
+// CollectUploadSessions removes upload sessions without appends since the time
+func (store *MemoryImageStore) CollectUploadSessions(idleSince time.Time) (int, error) {
+	// lock in-memory storage
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	collected := 0
+	for sessionID, memorySession := range store.sessions {
+		if memorySession.session.UpdatedAt.Before(idleSince) && !memorySession.session.finalizing {
+			log.Printf("removed upload session %s idle since %v", sessionID, memorySession.session.UpdatedAt)
+			delete(store.sessions, sessionID)
+			collected++
+		}
+	}
+
+	return collected, nil
+}
+
+// ListVersions returns copies of info of the current and kept previous versions of the image from the newest one
+func (store *MemoryImageStore) ListVersions(imageName string) ([]*ImageInfo, error) {
+	// lock in-memory storage for reading
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	// if no image with such name (or it is expired) return not found error
+	imageInfo := store.liveImage(imageName)
+	if imageInfo == nil {
+		return nil, logError(status.Errorf(codes.NotFound, "image doesn't exists: %v", imageName))
+	}
+
+	return newestVersions(imageInfo, store.versions[imageName]), nil
+}
+
+// RestoreVersion saves content of the kept version as a new current version of the image,
+// so the replaced current version is kept in the history as well
+func (store *MemoryImageStore) RestoreVersion(imageName string, version int64) (*ImageInfo, error) {
+	// trying to get the version with its content
+	versionInfo, data, err := store.findVersion(imageName, version)
+	if err != nil {
+		return nil, err
+	}
+
+	// trying to save content of the version as a new version
+	imageInfo := restoredImageInfo(versionInfo)
+	_, err = store.Save(imageInfo, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return imageInfo, nil
+}
+
+// EvictExpired removes images expired by the time with their previous versions
+func (store *MemoryImageStore) EvictExpired(now time.Time) (int, error) {
+	// lock in-memory storage
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	evicted := 0
+	for imageName, imageInfo := range store.images {
+		if imageInfo.expired(now) {
+			log.Printf("evicted expired image %s", imageName)
+			store.removeImage(imageName)
+			evicted++
+		}
+	}
+
+	return evicted, nil
+}
+
+// Usage returns logical size of images, previous versions and deleted images and size of their content kept in memory
+func (store *MemoryImageStore) Usage() StorageUsage {
+	// lock in-memory storage for reading
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return StorageUsage{Logical: logicalSize(store.images, store.versions, store.trash), Physical: store.size}
+}
+
+// ListTrash returns copies of deleted images kept in the trash from the most recently deleted one
+func (store *MemoryImageStore) ListTrash() []*TrashItem {
+	// lock in-memory storage for reading
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return listTrashItems(store.trash)
+}
+
+// RestoreTrashItem moves the deleted image and its previous versions back from the trash
+// and returns info of the restored image. An image with the same name must not exist.
+func (store *MemoryImageStore) RestoreTrashItem(trashID string) (*ImageInfo, error) {
+	// lock in-memory storage
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// check that the item exists and the name is free
+	item := store.trash[trashID]
+	if item == nil {
+		return nil, logError(status.Errorf(codes.NotFound, "trash item doesn't exists: %v", trashID))
+	}
+	imageName := item.ImageInfo.ImageName
+	if store.images[imageName] != nil && store.liveImage(imageName) == nil {
+		store.removeImage(imageName)
+	}
+	if store.images[imageName] != nil {
+		return nil, logError(status.Errorf(codes.AlreadyExists, "image already exists: %v", imageName))
+	}
+
+	store.images[imageName] = item.ImageInfo
+	if len(item.Versions) > 0 {
+		store.versions[imageName] = item.Versions
+	}
+	delete(store.trash, trashID)
+	store.touch(imageName)
+
+	imageInfoCopy := *item.ImageInfo
+	return &imageInfoCopy, nil
+}
+
+// PurgeTrash removes images deleted before the time from the trash and returns their number
+func (store *MemoryImageStore) PurgeTrash(deletedBefore time.Time) (int, error) {
+	// lock in-memory storage
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	purged := 0
+	for trashID, item := range store.trash {
+		if item.DeletedAt.Before(deletedBefore) {
+			delete(store.trash, trashID)
+			store.releaseImageBlobs(item.ImageInfo, item.Versions)
+			log.Printf("purged image %s deleted at %v", item.ImageInfo.ImageName, item.DeletedAt)
+			purged++
+		}
+	}
+
+	return purged, nil
+}
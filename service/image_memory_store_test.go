@@ -0,0 +1,115 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/MrPark97/tages/pb"
+	"github.com/MrPark97/tages/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMemoryImageStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+
+	// images of 100 bytes with distinct content within the budget of three images
+	images := make([][]byte, 4)
+	for i := range images {
+		images[i] = bytes.Repeat([]byte{byte(i + 1)}, 100)
+	}
+	budget := int64(300)
+	imageStore := service.NewMemoryImageStore(service.WithMemoryBudget(budget), service.WithMemoryVersionRetention(0))
+
+	for i, imageName := range []string{"first", "second", "third"} {
+		_, err := imageStore.Save(&service.ImageInfo{ImageName: imageName, Type: ".png"}, bytes.NewReader(images[i]))
+		require.NoError(t, err)
+	}
+
+	// reading the first image makes the second one the least recently used
+	require.Equal(t, images[0], downloadTestImage(t, imageStore, "first"))
+	_, err := imageStore.Save(&service.ImageInfo{ImageName: "fourth", Type: ".png"}, bytes.NewReader(images[3]))
+	require.NoError(t, err)
+
+	_, err = imageStore.Find("second")
+	require.Equal(t, codes.NotFound, status.Code(err))
+	for _, imageName := range []string{"first", "third", "fourth"} {
+		_, err = imageStore.Find(imageName)
+		require.NoError(t, err)
+	}
+	require.LessOrEqual(t, imageStore.Usage().Physical, budget)
+
+	// an image larger than the whole budget is rejected without evicting anything
+	_, err = imageStore.Save(&service.ImageInfo{ImageName: "large", Type: ".png"}, bytes.NewReader(make([]byte, budget+1)))
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Len(t, imageStore.List(service.ListOptions{}), 3)
+}
+
+func TestMemoryImageStoreDeduplicatesContent(t *testing.T) {
+	t.Parallel()
+
+	imageStore := service.NewMemoryImageStore()
+
+	imageData, err := os.ReadFile("../tmp/laptop.jpeg")
+	require.NoError(t, err)
+	imageSize := int64(len(imageData))
+
+	for _, imageName := range []string{"laptop", "laptop-copy"} {
+		_, err = imageStore.Save(&service.ImageInfo{ImageName: imageName, Type: ".jpeg"}, bytes.NewReader(imageData))
+		require.NoError(t, err)
+	}
+	require.Equal(t, service.StorageUsage{Logical: 2 * imageSize, Physical: imageSize}, imageStore.Usage())
+
+	// deleted images keep their content until they are purged
+	err = imageStore.Delete("laptop")
+	require.NoError(t, err)
+	err = imageStore.Delete("laptop-copy")
+	require.NoError(t, err)
+	require.Equal(t, imageSize, imageStore.Usage().Physical)
+
+	purged, err := imageStore.PurgeTrash(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 2, purged)
+	require.Equal(t, service.StorageUsage{}, imageStore.Usage())
+}
+
+func TestClientUploadSessionWithMemoryStore(t *testing.T) {
+	t.Parallel()
+
+	imageStore := service.NewMemoryImageStore()
+	serverAddress := startTestImageServer(t, imageStore)
+	imageClient := newTestImageClient(t, serverAddress)
+
+	imageData, err := os.ReadFile("../tmp/laptop.jpeg")
+	require.NoError(t, err)
+
+	createRes, err := imageClient.CreateUploadSession(context.Background(), &pb.CreateUploadSessionRequest{
+		Info: &pb.Info{Name: "laptop", Type: ".jpeg"},
+	})
+	require.NoError(t, err)
+	sessionID := createRes.GetSession().GetId()
+
+	half := len(imageData) / 2
+	committedOffset, err := appendTestUploadSession(imageClient, sessionID, 0, imageData[:half])
+	require.NoError(t, err)
+	require.Equal(t, uint64(half), committedOffset)
+
+	_, err = appendTestUploadSession(imageClient, sessionID, 0, imageData)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	_, err = appendTestUploadSession(imageClient, sessionID, uint64(half), imageData[half:])
+	require.NoError(t, err)
+
+	res, err := imageClient.FinalizeUploadSession(context.Background(), &pb.FinalizeUploadSessionRequest{SessionId: sessionID})
+	require.NoError(t, err)
+	require.Equal(t, uint32(len(imageData)), res.GetSize())
+
+	info, downloadedData, err := downloadTestImageRange(imageClient, &pb.DownloadImageRequest{Name: "laptop"})
+	require.NoError(t, err)
+	require.Equal(t, "jpeg", info.GetFormat())
+	require.Equal(t, imageData, downloadedData)
+}
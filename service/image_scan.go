@@ -0,0 +1,174 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// scanImageFolder reconciles images info with image files lying in image folder:
+// entries whose files disappeared are removed, entries whose files are replaced (size or modification time differs)
+// are recomputed from the files, paths follow the image folder and image files unknown to the index are added
+// with name and type derived from the file name, timestamps derived from the file times
+// image properties decoded from the file header and checksum of the file content (files which aren't images are skipped).
+// It returns true if images info was changed.
+func scanImageFolder(imageFolder string, images map[string]*ImageInfo) (bool, error) {
+	// trying to read image folder entries
+	entries, err := os.ReadDir(imageFolder)
+	if err != nil {
+		return false, fmt.Errorf("cannot read image folder: %w", err)
+	}
+
+	// collect image files grouped by image name (the same name may be stored with several types)
+	imageFiles := make(map[string][]os.FileInfo)
+	for _, entry := range entries {
+		fileName := entry.Name()
+
+		// remove leftovers of interrupted writes
+		if entry.Type().IsRegular() && isStaleServiceFile(fileName) {
+			log.Printf("removing leftover file %s", fileName)
+			os.Remove(filepath.Join(imageFolder, fileName))
+			continue
+		}
+
+		// skip folders, hidden and service files
+		if !entry.Type().IsRegular() || strings.HasPrefix(fileName, ".") {
+			continue
+		}
+
+		// skip files which don't look like images or can't be requested by name
+		imageType := filepath.Ext(fileName)
+		imageName := strings.TrimSuffix(fileName, imageType)
+		if ValidateImageName(imageName) != nil || ValidateImageType(imageType) != nil {
+			continue
+		}
+
+		fileInfo, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		imageFiles[imageName] = append(imageFiles[imageName], fileInfo)
+	}
+
+	changed := false
+
+	// remove entries whose files are gone and refresh entries whose files are changed
+	for imageName, imageInfo := range images {
+		var imageFile os.FileInfo
+		for _, fileInfo := range imageFiles[imageName] {
+			if fileInfo.Name() == imageName+imageInfo.Type {
+				imageFile = fileInfo
+				break
+			}
+		}
+		if imageFile == nil {
+			log.Printf("image file of %s%s is missing, removing it from the index", imageName, imageInfo.Type)
+			delete(images, imageName)
+			changed = true
+			continue
+		}
+
+		// keep the path in sync with the image folder (it may be moved since the index was written)
+		imagePath := filepath.Join(imageFolder, imageFile.Name())
+		if imageInfo.Path != imagePath {
+			imageInfo.Path = imagePath
+			changed = true
+		}
+		if imageInfo.Version == 0 {
+			imageInfo.Version = 1
+			changed = true
+		}
+
+		// recompute everything derived from the file content if the file is replaced (or it is missing in the index)
+		if imageInfo.Size == imageFile.Size() && imageInfo.FileModifiedAt.Equal(imageFile.ModTime()) &&
+			imageInfo.Checksum != "" && imageInfo.Format != "" {
+			continue
+		}
+		err := refreshImageInfo(imageInfo, imagePath, imageFile)
+		if err != nil {
+			log.Printf("image file %s isn't a readable image anymore, removing it from the index: %v", imageFile.Name(), err)
+			delete(images, imageName)
+		}
+		changed = true
+	}
+
+	// add image files unknown to the index
+	for imageName, fileInfos := range imageFiles {
+		if images[imageName] != nil {
+			continue
+		}
+
+		// prefer the most recently modified file if there are several types of the image
+		sort.Slice(fileInfos, func(i, j int) bool {
+			return fileInfos[i].ModTime().After(fileInfos[j].ModTime())
+		})
+
+		// take the first file which is really an image
+		var imageInfo *ImageInfo
+		for _, fileInfo := range fileInfos {
+			imageType := filepath.Ext(fileInfo.Name())
+			createdAt, updatedAt := fileTimes(fileInfo)
+			imageInfo = &ImageInfo{
+				ImageName: imageName,
+				Type:      imageType,
+				Path:      filepath.Join(imageFolder, fileInfo.Name()),
+				CreatedAt: createdAt,
+				UpdatedAt: updatedAt,
+				Version:   1,
+			}
+
+			err := refreshImageInfo(imageInfo, imageInfo.Path, fileInfo)
+			if err == nil {
+				break
+			}
+			log.Printf("skipping file %s: %v", fileInfo.Name(), err)
+			imageInfo = nil
+		}
+		if imageInfo == nil {
+			continue
+		}
+		images[imageName] = imageInfo
+
+		log.Printf("found image file %s%s, adding it to the index", imageName, imageInfo.Type)
+		changed = true
+	}
+
+	return changed, nil
+}
+
+// refreshImageInfo recomputes size, modification time, checksum and image properties of image info from the image file,
+// update time follows the file if its content differs from the recorded one
+func refreshImageInfo(imageInfo *ImageInfo, imagePath string, fileInfo os.FileInfo) error {
+	// trying to read checksum and image properties of the file
+	checksum, err := fileChecksum(imagePath)
+	if err != nil {
+		return err
+	}
+	err = readImageProperties(imageInfo, imagePath)
+	if err != nil {
+		return err
+	}
+
+	if imageInfo.Checksum != "" && imageInfo.Checksum != checksum {
+		log.Printf("content of image file %s is changed, updating its info", fileInfo.Name())
+		imageInfo.UpdatedAt = fileInfo.ModTime()
+	}
+	imageInfo.Checksum = checksum
+	imageInfo.Size = fileInfo.Size()
+	imageInfo.FileModifiedAt = fileInfo.ModTime()
+
+	return nil
+}
+
+// function to check if file is a leftover of an interrupted upload, replacement, deletion or index write
+func isStaleServiceFile(fileName string) bool {
+	for _, prefix := range []string{uploadFilePrefix, replacedFilePrefix, deletedFilePrefix, imageIndexFileName + "."} {
+		if strings.HasPrefix(fileName, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package service
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileTimes derives image creation and update times from file times.
+// Linux doesn't expose file creation time, so the earliest of ctime and mtime is used as creation time
+// (restored backups usually keep the original mtime while ctime is the restore time).
+func fileTimes(fileInfo os.FileInfo) (time.Time, time.Time) {
+	updatedAt := fileInfo.ModTime()
+	createdAt := updatedAt
+
+	if stat, ok := fileInfo.Sys().(*syscall.Stat_t); ok {
+		changedAt := time.Unix(stat.Ctim.Unix())
+		if changedAt.Before(createdAt) {
+			createdAt = changedAt
+		}
+	}
+
+	return createdAt, updatedAt
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package service
+
+import (
+	"os"
+	"time"
+)
+
+// fileTimes derives image creation and update times from file times (mtime is used for both)
+func fileTimes(fileInfo os.FileInfo) (time.Time, time.Time) {
+	return fileInfo.ModTime(), fileInfo.ModTime()
+}
@@ -2,23 +2,31 @@
 package service
 
 import (
-	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"sort"
+	"time"
 
 	"github.com/MrPark97/tages/pb"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-// maximum 1 megabyte
-const maxImageSize = 1 << 20
+// maximum 512 megabytes (images are streamed to disk, so the limit doesn't affect memory usage)
+const maxImageSize = 512 << 20
 
 // 1 kilobyte (just for test, optimal size is near 128 kB)
 const bufferSize = 1024
 
+// default and maximal number of images in the ListImages page
+const defaultListPageSize = 100
+const maxListPageSize = 1000
+
 // ImageServer is the server that provides Image services
 type ImageServer struct {
 	pb.UnimplementedImageServiceServer
@@ -26,6 +34,17 @@ type ImageServer struct {
 	UploadImageLimitChannel                  chan struct{}
 	DownloadImageLimitChannel                chan struct{}
 	GetUploadedImagesTableStringLimitChannel chan struct{}
+	ListImagesLimitChannel                   chan struct{}
+	DeleteImageLimitChannel                  chan struct{}
+	GetImageInfoLimitChannel                 chan struct{}
+	CreateUploadSessionLimitChannel          chan struct{}
+	AppendUploadSessionLimitChannel          chan struct{}
+	GetUploadSessionLimitChannel             chan struct{}
+	FinalizeUploadSessionLimitChannel        chan struct{}
+	ListImageVersionsLimitChannel            chan struct{}
+	RestoreImageVersionLimitChannel          chan struct{}
+	ListTrashLimitChannel                    chan struct{}
+	RestoreImageLimitChannel                 chan struct{}
 }
 
 // NewImageServer returns a new ImageServer
@@ -35,6 +54,53 @@ func NewImageServer(imageStore ImageStore) *ImageServer {
 		UploadImageLimitChannel:                  make(chan struct{}, 10),
 		DownloadImageLimitChannel:                make(chan struct{}, 10),
 		GetUploadedImagesTableStringLimitChannel: make(chan struct{}, 100),
+		ListImagesLimitChannel:                   make(chan struct{}, 100),
+		DeleteImageLimitChannel:                  make(chan struct{}, 100),
+		GetImageInfoLimitChannel:                 make(chan struct{}, 100),
+		CreateUploadSessionLimitChannel:          make(chan struct{}, 100),
+		AppendUploadSessionLimitChannel:          make(chan struct{}, 10),
+		GetUploadSessionLimitChannel:             make(chan struct{}, 100),
+		FinalizeUploadSessionLimitChannel:        make(chan struct{}, 10),
+		ListImageVersionsLimitChannel:            make(chan struct{}, 100),
+		RestoreImageVersionLimitChannel:          make(chan struct{}, 10),
+		ListTrashLimitChannel:                    make(chan struct{}, 100),
+		RestoreImageLimitChannel:                 make(chan struct{}, 10),
+	}
+}
+
+// limitChannel returns the channel limiting concurrent calls of the method (nil means no limit)
+func (server *ImageServer) limitChannel(methodName string) chan struct{} {
+	switch methodName {
+	case "UploadImage":
+		return server.UploadImageLimitChannel
+	case "DownloadImage":
+		return server.DownloadImageLimitChannel
+	case "GetUploadedImagesTableString":
+		return server.GetUploadedImagesTableStringLimitChannel
+	case "ListImages":
+		return server.ListImagesLimitChannel
+	case "DeleteImage":
+		return server.DeleteImageLimitChannel
+	case "GetImageInfo":
+		return server.GetImageInfoLimitChannel
+	case "CreateUploadSession":
+		return server.CreateUploadSessionLimitChannel
+	case "AppendUploadSession":
+		return server.AppendUploadSessionLimitChannel
+	case "GetUploadSession":
+		return server.GetUploadSessionLimitChannel
+	case "FinalizeUploadSession":
+		return server.FinalizeUploadSessionLimitChannel
+	case "ListImageVersions":
+		return server.ListImageVersionsLimitChannel
+	case "RestoreImageVersion":
+		return server.RestoreImageVersionLimitChannel
+	case "ListTrash":
+		return server.ListTrashLimitChannel
+	case "RestoreImage":
+		return server.RestoreImageLimitChannel
+	default:
+		return nil
 	}
 }
 
@@ -49,29 +115,116 @@ func (server *ImageServer) UploadImage(stream pb.ImageService_UploadImageServer)
 	// get image info from request
 	imageName := req.GetInfo().GetName()
 	imageType := req.GetInfo().GetType()
-	imageUpdateTime := req.GetInfo().GetUpdatedAt().AsTime()
 	log.Printf("receive an upload-image request with name %s and image type %s", imageName, imageType)
 
-	// init variables for bytes of image and image size
-	imageData := bytes.Buffer{}
-	imageSize := 0
+	// check that image name and type are safe to be stored
+	if err := ValidateImageName(imageName); err != nil {
+		return logError(err)
+	}
+	if err := ValidateImageType(imageType); err != nil {
+		return logError(err)
+	}
+	if err := ValidateChecksum(req.GetInfo().GetChecksum()); err != nil {
+		return logError(err)
+	}
+	if err := ValidateConflictPolicy(req.GetInfo().GetConflictPolicy()); err != nil {
+		return logError(err)
+	}
+	if err := ValidateExpectedVersion(req.GetInfo().GetExpectedVersion(), req.GetInfo().GetConflictPolicy()); err != nil {
+		return logError(err)
+	}
+	if err := ValidateExpiry(req.GetInfo().GetExpiresAt(), req.GetInfo().GetTtl()); err != nil {
+		return logError(err)
+	}
+
+	// init reader streaming image data from chunks of the request
+	imageData := &imageUploadReader{stream: stream}
+
+	// trying to check that image data is really an image of the declared type (before anything is written to disk)
+	imageConfig, imageFormat, imageReader, err := sniffImage(imageData, imageType)
+	if imageData.err != nil {
+		return imageData.err
+	}
+	if err != nil {
+		return logError(err)
+	}
+
+	// prepare image info (the store sets size, creation time and checksum of the saved image to it)
+	imageInfo := &ImageInfo{
+		ImageName:        imageName,
+		Type:             imageType,
+		SourceModifiedAt: sourceModifiedAt(req.GetInfo()),
+		Width:            imageConfig.Width,
+		Height:           imageConfig.Height,
+		ColorModel:       colorModelName(imageConfig.ColorModel),
+		Format:           imageFormat,
+		Checksum:         req.GetInfo().GetChecksum(),
+		ConflictPolicy:   req.GetInfo().GetConflictPolicy(),
+		ExpectedVersion:  int64(req.GetInfo().GetExpectedVersion()),
+		ExpiresAt:        timestampTime(req.GetInfo().GetExpiresAt()),
+		TimeToLive:       timeToLive(req.GetInfo()),
+	}
+
+	// trying to save image to disk and in-memory store chunk by chunk
+	imageName, err = server.imageStore.Save(imageInfo, imageReader)
+	if imageData.err != nil {
+		return imageData.err
+	}
+	if err != nil {
+		return storeError(err, "cannot save image to the store: %v")
+	}
+	imageSize := imageData.size
+
+	// forming response
+	res := &pb.UploadImageResponse{
+		Name:     imageName,
+		Size:     uint32(imageSize),
+		Checksum: imageInfo.Checksum,
+		Version:  uint64(imageInfo.Version),
+	}
+
+	// trying to send response
+	err = stream.SendAndClose(res)
+	if err != nil {
+		return logError(status.Errorf(codes.Unknown, "cannot send response: %v", err))
+	}
+
+	log.Printf("saved the image with name: %s, size: %d", imageName, imageSize)
+
+	return nil
+}
+
+// imageUploadReader is an io.Reader over chunks of data received from UploadImage stream
+type imageUploadReader struct {
+	stream pb.ImageService_UploadImageServer
+	// rest of the last received chunk
+	chunk []byte
+	// total size of received chunks
+	size int
+	// error to return to the client if receiving failed
+	err error
+}
 
-	for {
+// Read receives chunks of data from the stream until there is something to read
+func (reader *imageUploadReader) Read(p []byte) (int, error) {
+	for len(reader.chunk) == 0 {
 		// if there is context error returns it
-		if err := contextError(stream.Context()); err != nil {
-			return err
+		if err := contextError(reader.stream.Context()); err != nil {
+			reader.err = err
+			return 0, err
 		}
 
 		log.Print("waiting to receive more data")
 
-		// trying to get data chunk by chunk, break if there is no more data
-		req, err := stream.Recv()
+		// trying to get data chunk by chunk, return EOF if there is no more data
+		req, err := reader.stream.Recv()
 		if err == io.EOF {
 			log.Print("no more data")
-			break
+			return 0, io.EOF
 		}
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "cannot receive chunk data: %v", err))
+			reader.err = logError(status.Errorf(codes.Unknown, "cannot receive chunk data: %v", err))
+			return 0, reader.err
 		}
 
 		// getting chunk of data and calculating size
@@ -81,53 +234,51 @@ func (server *ImageServer) UploadImage(stream pb.ImageService_UploadImageServer)
 		log.Printf("received a chunk with size: %d", size)
 
 		// counting image size
-		imageSize += size
+		reader.size += size
 
 		// if image size is too large return error
-		if imageSize > maxImageSize {
-			return logError(status.Errorf(codes.InvalidArgument, "image is too large: %d > %d", imageSize, maxImageSize))
+		if reader.size > maxImageSize {
+			reader.err = logError(status.Errorf(codes.InvalidArgument, "image is too large: %d > %d", reader.size, maxImageSize))
+			return 0, reader.err
 		}
 
-		// trying to write data to buffer
-		_, err = imageData.Write(chunk)
-		if err != nil {
-			return logError(status.Errorf(codes.Internal, "cannot write chunk data: %v", err))
-		}
-	}
-
-	// trying to save image to disk and in-memory store
-	imageName, err = server.imageStore.Save(imageName, imageType, imageData, imageUpdateTime)
-	if err != nil {
-		return logError(status.Errorf(codes.Internal, "cannot save image to the store: %v", err))
-	}
-
-	// forming response
-	res := &pb.UploadImageResponse{
-		Name: imageName,
-		Size: uint32(imageSize),
+		reader.chunk = chunk
 	}
 
-	// trying to send response
-	err = stream.SendAndClose(res)
-	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "cannot send response: %v", err))
-	}
+	n := copy(p, reader.chunk)
+	reader.chunk = reader.chunk[n:]
 
-	log.Printf("saved the image with name: %s, size: %d", imageName, imageSize)
-
-	return nil
+	return n, nil
 }
 
 // DownloadImage is a server-streaming RPC to download an image
 func (server *ImageServer) DownloadImage(req *pb.DownloadImageRequest, stream pb.ImageService_DownloadImageServer) error {
 	// get image name from request
 	imageName := req.GetName()
-	log.Printf("receive name of an image from request: %v", imageName)
+	log.Printf("receive name of an image from request: %v, offset: %d, length: %d", imageName, req.GetOffset(), req.GetLength())
+
+	// check image name
+	if err := ValidateImageName(imageName); err != nil {
+		return logError(err)
+	}
+
+	// check expected checksum
+	if err := ValidateChecksum(req.GetExpectedChecksum()); err != nil {
+		return logError(err)
+	}
 
 	// trying to send image from storage
 	err := server.imageStore.Send(
 		stream,
 		imageName,
+		SendOptions{
+			Offset:           int64(req.GetOffset()),
+			Length:           int64(req.GetLength()),
+			Version:          int64(req.GetVersion()),
+			ExpectedChecksum: req.GetExpectedChecksum(),
+			IfNoneMatch:      req.GetIfNoneMatch(),
+			IfModifiedSince:  timestampTime(req.GetIfModifiedSince()),
+		},
 		func(chunkData []byte) error {
 			// forming response with chunk data
 			res := &pb.DownloadImageResponse{
@@ -147,9 +298,9 @@ func (server *ImageServer) DownloadImage(req *pb.DownloadImageRequest, stream pb
 		},
 	)
 
-	// if there is unexpected error return it
+	// if there is an error return it
 	if err != nil {
-		return status.Errorf(codes.Internal, "unxepected error: %v", err)
+		return storeError(err, "unexpected error: %v")
 	}
 
 	return nil
@@ -157,9 +308,11 @@ func (server *ImageServer) DownloadImage(req *pb.DownloadImageRequest, stream pb
 
 // GetUploadedImageTableString forms table string with info about uploaded images and returns it
 func (server *ImageServer) GetUploadedImagesTableString(ctx context.Context, req *pb.GetUploadedImagesTableStringRequest) (*pb.GetUploadedImagesTableStringResponse, error) {
-	// get limit from request
+	// get limit and sorting from request
 	limit := req.GetLimit()
-	log.Printf("receive request to form images table string with limit (zero means all images): %d", limit)
+	sortField := req.GetSortField()
+	sortOrder := req.GetSortOrder()
+	log.Printf("receive request to form images table string with limit (zero means all images): %d, sorted by %v %v", limit, sortField, sortOrder)
 
 	// check for context errors
 	if err := contextError(ctx); err != nil {
@@ -167,7 +320,7 @@ func (server *ImageServer) GetUploadedImagesTableString(ctx context.Context, req
 	}
 
 	// trying to form images table string
-	uploadedImagesTableString := server.imageStore.String(limit)
+	uploadedImagesTableString := server.imageStore.String(limit, sortField, sortOrder)
 
 	// log results
 	log.Printf("formed images table string with limit: %d", limit)
@@ -181,6 +334,573 @@ func (server *ImageServer) GetUploadedImagesTableString(ctx context.Context, req
 	return res, nil
 }
 
+// ListImages returns a page of images info matching the filters in the requested order
+func (server *ImageServer) ListImages(ctx context.Context, req *pb.ListImagesRequest) (*pb.ListImagesResponse, error) {
+	log.Printf("receive request to list images with page size: %d", req.GetPageSize())
+
+	// check for context errors
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	// get page size from request
+	pageSize := int(req.GetPageSize())
+	if pageSize == 0 {
+		pageSize = defaultListPageSize
+	}
+	if pageSize > maxListPageSize {
+		pageSize = maxListPageSize
+	}
+
+	// get list options from request
+	options := ListOptions{
+		SortField:     req.GetSortField(),
+		SortOrder:     req.GetSortOrder(),
+		NamePrefix:    req.GetNamePrefix(),
+		Type:          req.GetType(),
+		CreatedAfter:  timestampTime(req.GetCreatedAfter()),
+		CreatedBefore: timestampTime(req.GetCreatedBefore()),
+		UpdatedAfter:  timestampTime(req.GetUpdatedAfter()),
+		UpdatedBefore: timestampTime(req.GetUpdatedBefore()),
+	}
+
+	// trying to get the last image of the previous page from page token
+	lastImage, err := decodePageToken(req.GetPageToken(), options)
+	if err != nil {
+		return nil, logError(status.Errorf(codes.InvalidArgument, "invalid page token: %v", err))
+	}
+
+	// get matching images from the store
+	images := server.imageStore.List(options)
+
+	// find the page start right after the last image of the previous page (images are sorted, so binary search is used),
+	// so images saved or deleted meanwhile don't shift the page
+	start := 0
+	if lastImage != nil {
+		start = sort.Search(len(images), func(i int) bool {
+			return imageBefore(lastImage, images[i], options.SortField, options.SortOrder)
+		})
+	}
+	end := start + pageSize
+	if end > len(images) {
+		end = len(images)
+	}
+
+	// form response with the requested page
+	res := &pb.ListImagesResponse{
+		TotalSize: uint32(len(images)),
+	}
+	for _, imageInfo := range images[start:end] {
+		res.Images = append(res.Images, imageInfo.toInfo())
+	}
+	if end < len(images) {
+		res.NextPageToken = encodePageToken(images[end-1], options)
+	}
+
+	log.Printf("listed %d of %d images", len(res.Images), len(images))
+
+	return res, nil
+}
+
+// DeleteImage moves an image to the trash of the store
+func (server *ImageServer) DeleteImage(ctx context.Context, req *pb.DeleteImageRequest) (*pb.DeleteImageResponse, error) {
+	// get image name from request
+	imageName := req.GetName()
+	log.Printf("receive request to delete image with name: %s", imageName)
+
+	// check image name
+	if err := ValidateImageName(imageName); err != nil {
+		return nil, logError(err)
+	}
+
+	// check for context errors
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	// trying to delete image from the store
+	err := server.imageStore.Delete(imageName)
+	if err != nil {
+		return nil, storeError(err, "cannot delete image from the store: %v")
+	}
+
+	log.Printf("moved the image with name %s to the trash", imageName)
+
+	return &pb.DeleteImageResponse{Name: imageName}, nil
+}
+
+// GetImageInfo returns info of an image without its content
+func (server *ImageServer) GetImageInfo(ctx context.Context, req *pb.GetImageInfoRequest) (*pb.GetImageInfoResponse, error) {
+	// get image name from request
+	imageName := req.GetName()
+	log.Printf("receive request to get info of image with name: %s", imageName)
+
+	// check image name
+	if err := ValidateImageName(imageName); err != nil {
+		return nil, logError(err)
+	}
+
+	// check for context errors
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	// trying to find image in the store
+	imageInfo, err := server.imageStore.Find(imageName)
+	if err != nil {
+		return nil, storeError(err, "cannot find image in the store: %v")
+	}
+
+	return &pb.GetImageInfoResponse{Info: imageInfo.toInfo()}, nil
+}
+
+// CreateUploadSession opens a resumable upload of an image and returns its session
+func (server *ImageServer) CreateUploadSession(ctx context.Context, req *pb.CreateUploadSessionRequest) (*pb.CreateUploadSessionResponse, error) {
+	// get image info from request
+	imageName := req.GetInfo().GetName()
+	imageType := req.GetInfo().GetType()
+	log.Printf("receive request to create upload session for image with name %s and image type %s", imageName, imageType)
+
+	// check that image name and type are safe to be stored
+	if err := ValidateImageName(imageName); err != nil {
+		return nil, logError(err)
+	}
+	if err := ValidateImageType(imageType); err != nil {
+		return nil, logError(err)
+	}
+	if err := ValidateChecksum(req.GetInfo().GetChecksum()); err != nil {
+		return nil, logError(err)
+	}
+	if err := ValidateConflictPolicy(req.GetInfo().GetConflictPolicy()); err != nil {
+		return nil, logError(err)
+	}
+	if err := ValidateExpectedVersion(req.GetInfo().GetExpectedVersion(), req.GetInfo().GetConflictPolicy()); err != nil {
+		return nil, logError(err)
+	}
+	if err := ValidateExpiry(req.GetInfo().GetExpiresAt(), req.GetInfo().GetTtl()); err != nil {
+		return nil, logError(err)
+	}
+
+	// check for context errors
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	// trying to create upload session in the store
+	session, err := server.imageStore.CreateUploadSession(&ImageInfo{
+		ImageName:        imageName,
+		Type:             imageType,
+		SourceModifiedAt: sourceModifiedAt(req.GetInfo()),
+		Checksum:         req.GetInfo().GetChecksum(),
+		ConflictPolicy:   req.GetInfo().GetConflictPolicy(),
+		ExpectedVersion:  int64(req.GetInfo().GetExpectedVersion()),
+		ExpiresAt:        timestampTime(req.GetInfo().GetExpiresAt()),
+		TimeToLive:       timeToLive(req.GetInfo()),
+	})
+	if err != nil {
+		return nil, storeError(err, "cannot create upload session in the store: %v")
+	}
+
+	log.Printf("created upload session %s for image with name: %s", session.ID, imageName)
+
+	return &pb.CreateUploadSessionResponse{Session: session.toUploadSession()}, nil
+}
+
+// AppendUploadSession is a client-streaming RPC to append chunks of data to an upload session.
+// Data received before the stream is broken stays committed, so the client can continue from the committed offset.
+func (server *ImageServer) AppendUploadSession(stream pb.ImageService_AppendUploadSessionServer) error {
+	// trying to receive the first chunk with session identifier and offset
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return logError(status.Errorf(codes.InvalidArgument, "no upload session id received"))
+	}
+	if err != nil {
+		return logError(status.Errorf(codes.Unknown, "cannot receive the first chunk: %v", err))
+	}
+
+	// get session identifier and offset from request
+	sessionID := req.GetSessionId()
+	offset := int64(req.GetOffset())
+	log.Printf("receive request to append data to upload session %s from offset %d", sessionID, offset)
+
+	// init reader streaming session data from chunks of the request
+	sessionData := &uploadSessionReader{stream: stream, sessionID: sessionID, offset: offset}
+	if err := sessionData.accept(req); err != nil {
+		return err
+	}
+
+	// trying to append data to the session chunk by chunk
+	session, err := server.imageStore.AppendUploadSession(sessionID, offset, sessionData)
+	if sessionData.err != nil {
+		return sessionData.err
+	}
+	if err != nil {
+		return storeError(err, "cannot append data to upload session: %v")
+	}
+
+	// trying to send response
+	err = stream.SendAndClose(&pb.AppendUploadSessionResponse{Session: session.toUploadSession()})
+	if err != nil {
+		return logError(status.Errorf(codes.Unknown, "cannot send response: %v", err))
+	}
+
+	log.Printf("committed %d bytes of upload session %s", session.Offset, sessionID)
+
+	return nil
+}
+
+// uploadSessionReader is an io.Reader over chunks of data received from AppendUploadSession stream
+type uploadSessionReader struct {
+	stream    pb.ImageService_AppendUploadSessionServer
+	sessionID string
+	// offset of the end of received chunks
+	offset int64
+	// rest of the last received chunk
+	chunk []byte
+	// error to return to the client if receiving failed
+	err error
+}
+
+// accept checks that the chunk continues received data of the same session and makes it the one to read
+func (reader *uploadSessionReader) accept(req *pb.AppendUploadSessionRequest) error {
+	chunk := req.GetChunkData()
+
+	// check that chunk belongs to the session and follows the previous one
+	if req.GetSessionId() != reader.sessionID {
+		reader.err = logError(status.Errorf(codes.InvalidArgument, "chunk of upload session %s received in the stream of %s", req.GetSessionId(), reader.sessionID))
+		return reader.err
+	}
+	if int64(req.GetOffset()) != reader.offset {
+		reader.err = logError(status.Errorf(codes.InvalidArgument, "chunk offset %d doesn't follow the previous chunk ending at %d", req.GetOffset(), reader.offset))
+		return reader.err
+	}
+
+	// counting image size, if image size is too large return error
+	reader.offset += int64(len(chunk))
+	if reader.offset > maxImageSize {
+		reader.err = logError(status.Errorf(codes.InvalidArgument, "image is too large: %d > %d", reader.offset, maxImageSize))
+		return reader.err
+	}
+
+	reader.chunk = chunk
+
+	return nil
+}
+
+// Read receives chunks of data from the stream until there is something to read
+func (reader *uploadSessionReader) Read(p []byte) (int, error) {
+	for len(reader.chunk) == 0 {
+		// if there is context error returns it
+		if err := contextError(reader.stream.Context()); err != nil {
+			reader.err = err
+			return 0, err
+		}
+
+		// trying to get data chunk by chunk, return EOF if there is no more data
+		req, err := reader.stream.Recv()
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		if err != nil {
+			reader.err = logError(status.Errorf(codes.Unknown, "cannot receive chunk data: %v", err))
+			return 0, reader.err
+		}
+
+		err = reader.accept(req)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, reader.chunk)
+	reader.chunk = reader.chunk[n:]
+
+	return n, nil
+}
+
+// GetUploadSession returns state of an upload session (e.g. committed offset to continue from after reconnecting)
+func (server *ImageServer) GetUploadSession(ctx context.Context, req *pb.GetUploadSessionRequest) (*pb.GetUploadSessionResponse, error) {
+	// get session identifier from request
+	sessionID := req.GetSessionId()
+	log.Printf("receive request to get upload session %s", sessionID)
+
+	// check for context errors
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	// trying to find session in the store
+	session, err := server.imageStore.FindUploadSession(sessionID)
+	if err != nil {
+		return nil, storeError(err, "cannot find upload session in the store: %v")
+	}
+
+	return &pb.GetUploadSessionResponse{Session: session.toUploadSession()}, nil
+}
+
+// FinalizeUploadSession saves data committed to an upload session as an image and removes the session
+func (server *ImageServer) FinalizeUploadSession(ctx context.Context, req *pb.FinalizeUploadSessionRequest) (*pb.UploadImageResponse, error) {
+	// get session identifier from request
+	sessionID := req.GetSessionId()
+	log.Printf("receive request to finalize upload session %s", sessionID)
+
+	// check for context errors
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	// trying to open data committed to the session
+	session, sessionData, err := server.imageStore.OpenUploadSession(sessionID)
+	if err != nil {
+		return nil, storeError(err, "cannot open upload session in the store: %v")
+	}
+	defer sessionData.Close()
+
+	// trying to check that session data is really an image of the declared type
+	imageConfig, imageFormat, imageReader, err := sniffImage(sessionData, session.ImageInfo.Type)
+	if err != nil {
+		return nil, logError(err)
+	}
+
+	// prepare image info (the store sets size, creation time and checksum of the saved image to it)
+	imageInfo := &ImageInfo{
+		ImageName:        session.ImageInfo.ImageName,
+		Type:             session.ImageInfo.Type,
+		SourceModifiedAt: session.ImageInfo.SourceModifiedAt,
+		Width:            imageConfig.Width,
+		Height:           imageConfig.Height,
+		ColorModel:       colorModelName(imageConfig.ColorModel),
+		Format:           imageFormat,
+		Checksum:         session.ImageInfo.Checksum,
+		ConflictPolicy:   session.ImageInfo.ConflictPolicy,
+		ExpectedVersion:  session.ImageInfo.ExpectedVersion,
+		ExpiresAt:        session.ImageInfo.ExpiresAt,
+		TimeToLive:       session.ImageInfo.TimeToLive,
+	}
+
+	// trying to save image to disk and in-memory store
+	imageName, err := server.imageStore.Save(imageInfo, imageReader)
+	if err != nil {
+		return nil, storeError(err, "cannot save image to the store: %v")
+	}
+
+	// trying to remove finished session (an abandoned session is collected later anyway)
+	err = server.imageStore.DeleteUploadSession(sessionID)
+	if err != nil {
+		log.Printf("cannot remove finalized upload session %s: %v", sessionID, err)
+	}
+
+	log.Printf("saved the image with name: %s, size: %d from upload session %s", imageName, imageInfo.Size, sessionID)
+
+	return &pb.UploadImageResponse{
+		Name:     imageName,
+		Size:     uint32(imageInfo.Size),
+		Checksum: imageInfo.Checksum,
+		Version:  uint64(imageInfo.Version),
+	}, nil
+}
+
+// ListImageVersions returns info of the current and kept previous versions of an image from the newest one
+func (server *ImageServer) ListImageVersions(ctx context.Context, req *pb.ListImageVersionsRequest) (*pb.ListImageVersionsResponse, error) {
+	// get image name from request
+	imageName := req.GetName()
+	log.Printf("receive request to list versions of image with name: %s", imageName)
+
+	// check image name
+	if err := ValidateImageName(imageName); err != nil {
+		return nil, logError(err)
+	}
+
+	// check for context errors
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	// trying to get versions from the store
+	versions, err := server.imageStore.ListVersions(imageName)
+	if err != nil {
+		return nil, storeError(err, "cannot list image versions in the store: %v")
+	}
+
+	// form response
+	res := &pb.ListImageVersionsResponse{}
+	for _, versionInfo := range versions {
+		res.Versions = append(res.Versions, versionInfo.toInfo())
+	}
+
+	return res, nil
+}
+
+// RestoreImageVersion makes content of a kept version of an image current (as a new version)
+func (server *ImageServer) RestoreImageVersion(ctx context.Context, req *pb.RestoreImageVersionRequest) (*pb.RestoreImageVersionResponse, error) {
+	// get image name and version from request
+	imageName := req.GetName()
+	version := int64(req.GetVersion())
+	log.Printf("receive request to restore version %d of image with name: %s", version, imageName)
+
+	// check image name and version
+	if err := ValidateImageName(imageName); err != nil {
+		return nil, logError(err)
+	}
+	if version <= 0 {
+		return nil, logError(status.Errorf(codes.InvalidArgument, "version must be positive: %d", req.GetVersion()))
+	}
+
+	// check for context errors
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	// trying to restore version in the store
+	imageInfo, err := server.imageStore.RestoreVersion(imageName, version)
+	if err != nil {
+		return nil, storeError(err, "cannot restore image version in the store: %v")
+	}
+
+	log.Printf("restored version %d of image %s as version %d", version, imageName, imageInfo.Version)
+
+	return &pb.RestoreImageVersionResponse{Info: imageInfo.toInfo()}, nil
+}
+
+// ListTrash returns deleted images kept in the trash
+func (server *ImageServer) ListTrash(ctx context.Context, req *pb.ListTrashRequest) (*pb.ListTrashResponse, error) {
+	log.Printf("receive request to list trash")
+
+	// check for context errors
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	// form response
+	res := &pb.ListTrashResponse{}
+	for _, item := range server.imageStore.ListTrash() {
+		res.Items = append(res.Items, item.toTrashItem())
+	}
+
+	return res, nil
+}
+
+// RestoreImage moves a deleted image back from the trash
+func (server *ImageServer) RestoreImage(ctx context.Context, req *pb.RestoreImageRequest) (*pb.RestoreImageResponse, error) {
+	// get trash item identifier from request
+	trashID := req.GetId()
+	log.Printf("receive request to restore image from trash item: %s", trashID)
+
+	// check for context errors
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	// trying to restore image in the store
+	imageInfo, err := server.imageStore.RestoreTrashItem(trashID)
+	if err != nil {
+		return nil, storeError(err, "cannot restore image in the store: %v")
+	}
+
+	log.Printf("restored the image with name %s from the trash", imageInfo.ImageName)
+
+	return &pb.RestoreImageResponse{Info: imageInfo.toInfo()}, nil
+}
+
+// pageToken is a struct to operate position of the next page: sort key and name of the last image of the previous page
+// with the order and filters it is valid for
+type pageToken struct {
+	SortField pb.SortField `json:"sort_field"`
+	SortOrder pb.SortOrder `json:"sort_order"`
+	// hash of the filters of the listing
+	Filters   string    `json:"filters"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Size      int64     `json:"size"`
+}
+
+// function to encode the last image of the page listed with the options to the page token
+func encodePageToken(imageInfo *ImageInfo, options ListOptions) string {
+	token := pageToken{SortField: options.SortField, SortOrder: options.SortOrder, Filters: options.filtersHash(), Name: imageInfo.ImageName}
+	switch options.SortField {
+	case pb.SortField_SORT_FIELD_CREATED_AT:
+		token.CreatedAt = imageInfo.CreatedAt
+	case pb.SortField_SORT_FIELD_UPDATED_AT:
+		token.UpdatedAt = imageInfo.UpdatedAt
+	case pb.SortField_SORT_FIELD_SIZE:
+		token.Size = imageInfo.Size
+	}
+
+	// encoding can't fail, the token consists of plain values only
+	data, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// function to decode the last image of the previous page from the page token (empty token means the first page),
+// the token must be issued for the same sort field, order and filters
+func decodePageToken(encodedToken string, options ListOptions) (*ImageInfo, error) {
+	if encodedToken == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(encodedToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var token pageToken
+	if err := json.Unmarshal(data, &token); err != nil || token.Name == "" {
+		return nil, fmt.Errorf("malformed position")
+	}
+	if token.SortField != options.SortField || token.SortOrder != options.SortOrder {
+		return nil, fmt.Errorf("token is issued for another sort order")
+	}
+	if token.Filters != options.filtersHash() {
+		return nil, fmt.Errorf("token is issued for other filters")
+	}
+
+	return &ImageInfo{
+		ImageName: token.Name,
+		CreatedAt: token.CreatedAt,
+		UpdatedAt: token.UpdatedAt,
+		Size:      token.Size,
+	}, nil
+}
+
+// function to convert optional timestamp to time (unset timestamp means zero time)
+func timestampTime(timestamp *timestamppb.Timestamp) time.Time {
+	if timestamp == nil {
+		return time.Time{}
+	}
+	return timestamp.AsTime()
+}
+
+// function to get source modification time of the uploaded image
+// (clients which don't know about source_modified_at send it as updated_at), zero time means it is unknown
+func sourceModifiedAt(info *pb.Info) time.Time {
+	switch {
+	case info.GetSourceModifiedAt() != nil:
+		return info.GetSourceModifiedAt().AsTime()
+	case info.GetUpdatedAt() != nil:
+		return info.GetUpdatedAt().AsTime()
+	default:
+		return time.Time{}
+	}
+}
+
+// function to get time to live of the uploaded image, it is counted by the store from the moment the image is committed
+// (zero means the image doesn't expire unless expiration time is set)
+func timeToLive(info *pb.Info) time.Duration {
+	if info.GetTtl() == nil {
+		return 0
+	}
+	return info.GetTtl().AsDuration()
+}
+
+// function to return store errors which are already grpc statuses as is and wrap other errors as internal ones
+func storeError(err error, format string) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return logError(status.Errorf(codes.Internal, format, err))
+}
+
 // function to log and return error
 func logError(err error) error {
 	if err != nil {
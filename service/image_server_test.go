@@ -1,23 +1,249 @@
 package service_test
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/MrPark97/tages/pb"
 	"github.com/MrPark97/tages/service"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestServerGetUploadedImagesTableString(t *testing.T) {
 	t.Parallel()
 
-	imageStore := service.NewDiskImageStore("../tmp")
-	server := service.NewImageServer(imageStore)
+	server := service.NewImageServer(service.NewMemoryImageStore())
 	req := &pb.GetUploadedImagesTableStringRequest{
 		Limit: uint32(0),
 	}
 	res, err := server.GetUploadedImagesTableString(context.Background(), req)
 	require.NoError(t, err)
-	require.EqualValues(t, res.GetTable(), "Имя файла | Дата создания       | Дата обновления\n")
+	require.EqualValues(t, res.GetTable(), "Имя файла | Дата создания       | Дата обновления     | Размер | Разрешение | Формат | Цветовая модель\n"+
+		"Логический размер: 0 | Физический размер: 0\n")
+}
+
+func TestServerGetUploadedImagesTableStringSortedWithLimit(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{}
+	imageStore, err := service.NewDiskImageStore(t.TempDir(), service.WithClock(clock.Now))
+	require.NoError(t, err)
+	server := service.NewImageServer(imageStore)
+
+	createdAt := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock.Set(createdAt.Add(2 * time.Hour))
+	saveTestImage(t, imageStore, "laptop", "../tmp/laptop.jpeg")
+	clock.Set(createdAt.Add(1 * time.Hour))
+	saveTestImage(t, imageStore, "macbook", "../tmp/macbook.png")
+	clock.Set(createdAt.Add(3 * time.Hour))
+	saveTestImage(t, imageStore, "mac-mini", "../tmp/macbook.png")
+
+	// two most recently updated images
+	req := &pb.GetUploadedImagesTableStringRequest{
+		Limit:     uint32(2),
+		SortField: pb.SortField_SORT_FIELD_UPDATED_AT,
+		SortOrder: pb.SortOrder_SORT_ORDER_DESC,
+	}
+
+	expectedTable := "" +
+		"Имя файла    | Дата создания       | Дата обновления     | Размер | Разрешение | Формат | Цветовая модель\n" +
+		"mac-mini.png | %[1]s | %[1]s | 68211  | 745x401    | png    | Paletted\n" +
+		"laptop.jpeg  | %[2]s | %[2]s | 140097 | 1500x1000  | jpeg   | YCbCr\n" +
+		// usage covers all images, mac-mini and macbook share content
+		"Логический размер: 276519 | Физический размер: 208308\n"
+	expectedTable = fmt.Sprintf(expectedTable,
+		createdAt.Add(3*time.Hour).Local().Format("02.01.2006 15:04:05"),
+		createdAt.Add(2*time.Hour).Local().Format("02.01.2006 15:04:05"),
+	)
+
+	// the result must be the same on every call
+	for i := 0; i < 5; i++ {
+		res, err := server.GetUploadedImagesTableString(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, expectedTable, res.GetTable())
+	}
+}
+
+func TestServerListImages(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{}
+	imageStore, err := service.NewDiskImageStore(t.TempDir(), service.WithClock(clock.Now))
+	require.NoError(t, err)
+	server := service.NewImageServer(imageStore)
+
+	baseTime := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock.Set(baseTime.Add(2 * time.Hour))
+	saveTestImage(t, imageStore, "laptop", "../tmp/laptop.jpeg")
+	clock.Set(baseTime.Add(1 * time.Hour))
+	saveTestImage(t, imageStore, "macbook", "../tmp/macbook.png")
+	clock.Set(baseTime.Add(3 * time.Hour))
+	saveTestImage(t, imageStore, "mac-mini", "../tmp/macbook.png")
+
+	// first page of images sorted by update time descending
+	req := &pb.ListImagesRequest{
+		PageSize:  2,
+		SortField: pb.SortField_SORT_FIELD_UPDATED_AT,
+		SortOrder: pb.SortOrder_SORT_ORDER_DESC,
+	}
+	res, err := server.ListImages(context.Background(), req)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, res.GetTotalSize())
+	require.Len(t, res.GetImages(), 2)
+	require.Equal(t, "mac-mini", res.GetImages()[0].GetName())
+	require.Equal(t, "laptop", res.GetImages()[1].GetName())
+	require.NotEmpty(t, res.GetNextPageToken())
+
+	// the token of another sort order or other filters is rejected
+	_, err = server.ListImages(context.Background(), &pb.ListImagesRequest{PageToken: res.GetNextPageToken()})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = server.ListImages(context.Background(), &pb.ListImagesRequest{
+		PageToken:  res.GetNextPageToken(),
+		SortField:  pb.SortField_SORT_FIELD_UPDATED_AT,
+		SortOrder:  pb.SortOrder_SORT_ORDER_DESC,
+		NamePrefix: "mac",
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// second (last) page continues after the last listed image even if it is deleted meanwhile
+	require.NoError(t, imageStore.Delete("laptop"))
+	req.PageToken = res.GetNextPageToken()
+	res, err = server.ListImages(context.Background(), req)
+	require.NoError(t, err)
+	require.Len(t, res.GetImages(), 1)
+	require.Equal(t, "macbook", res.GetImages()[0].GetName())
+	require.Equal(t, ".png", res.GetImages()[0].GetType())
+	require.NotZero(t, res.GetImages()[0].GetSize())
+	require.Empty(t, res.GetNextPageToken())
+
+	// filters by name prefix, type and update time
+	res, err = server.ListImages(context.Background(), &pb.ListImagesRequest{
+		NamePrefix:   "mac",
+		Type:         ".png",
+		UpdatedAfter: timestamppb.New(baseTime.Add(90 * time.Minute)),
+	})
+	require.NoError(t, err)
+	require.Len(t, res.GetImages(), 1)
+	require.Equal(t, "mac-mini", res.GetImages()[0].GetName())
+
+	// malformed page token
+	_, err = server.ListImages(context.Background(), &pb.ListImagesRequest{PageToken: "???"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// testClock is a clock of the store returning the time set by the test
+type testClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func (clock *testClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+func (clock *testClock) Set(now time.Time) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.now = now
+}
+
+func saveTestImage(t *testing.T, imageStore service.ImageStore, imageName string, imagePath string) {
+	imageData, err := os.ReadFile(imagePath)
+	require.NoError(t, err)
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(imageData))
+	require.NoError(t, err)
+
+	colorModel := "YCbCr"
+	if _, ok := config.ColorModel.(color.Palette); ok {
+		colorModel = "Paletted"
+	}
+
+	_, err = imageStore.Save(&service.ImageInfo{
+		ImageName:  imageName,
+		Type:       filepath.Ext(imagePath),
+		Width:      config.Width,
+		Height:     config.Height,
+		ColorModel: colorModel,
+		Format:     format,
+	}, bytes.NewReader(imageData))
+	require.NoError(t, err)
+}
+
+func TestServerGetImageInfo(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{}
+	imageStore, err := service.NewDiskImageStore(t.TempDir(), service.WithClock(clock.Now))
+	require.NoError(t, err)
+	server := service.NewImageServer(imageStore)
+
+	createdAt := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock.Set(createdAt)
+	saveTestImage(t, imageStore, "laptop", "../tmp/laptop.jpeg")
+	clock.Set(createdAt.Add(time.Hour))
+	saveTestImage(t, imageStore, "laptop", "../tmp/laptop.jpeg")
+
+	res, err := server.GetImageInfo(context.Background(), &pb.GetImageInfoRequest{Name: "laptop"})
+	require.NoError(t, err)
+
+	info := res.GetInfo()
+	require.Equal(t, "laptop", info.GetName())
+	require.Equal(t, ".jpeg", info.GetType())
+	require.EqualValues(t, 140097, info.GetSize())
+	require.EqualValues(t, 1500, info.GetWidth())
+	require.EqualValues(t, 1000, info.GetHeight())
+	require.True(t, createdAt.Equal(info.GetCreatedAt().AsTime()))
+	require.True(t, createdAt.Add(time.Hour).Equal(info.GetUpdatedAt().AsTime()))
+
+	_, err = server.GetImageInfo(context.Background(), &pb.GetImageInfoRequest{Name: "macbook"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = server.GetImageInfo(context.Background(), &pb.GetImageInfoRequest{Name: "../laptop"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServerDeleteImage(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	imageStore, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+	server := service.NewImageServer(imageStore)
+
+	saveTestImage(t, imageStore, "laptop", "../tmp/laptop.jpeg")
+	saveTestImage(t, imageStore, "macbook", "../tmp/macbook.png")
+
+	res, err := server.DeleteImage(context.Background(), &pb.DeleteImageRequest{Name: "laptop"})
+	require.NoError(t, err)
+	require.Equal(t, "laptop", res.GetName())
+	require.NoFileExists(t, filepath.Join(imageFolder, "laptop.jpeg"))
+	require.FileExists(t, filepath.Join(imageFolder, "macbook.png"))
+
+	// deleted image is gone from the listing, also after reloading the store
+	listRes, err := server.ListImages(context.Background(), &pb.ListImagesRequest{})
+	require.NoError(t, err)
+	require.Len(t, listRes.GetImages(), 1)
+	require.Equal(t, "macbook", listRes.GetImages()[0].GetName())
+
+	reloadedStore, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+	require.Len(t, reloadedStore.List(service.ListOptions{}), 1)
+
+	// unknown image
+	_, err = server.DeleteImage(context.Background(), &pb.DeleteImageRequest{Name: "laptop"})
+	require.Equal(t, codes.NotFound, status.Code(err))
 }
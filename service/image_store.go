@@ -2,10 +2,14 @@ package service
 
 import (
 	"bufio"
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -14,91 +18,623 @@ import (
 	"github.com/MrPark97/tages/pb"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // ImageStore is an interface to store images
 type ImageStore interface {
-	// Save saves a new image to the store
-	Save(imageName string, imageType string, imageData bytes.Buffer, imageUpdateTime time.Time) (string, error)
-	// Send sends an existing image to the client from the store
-	Send(stream pb.ImageService_DownloadImageServer, imageName string, send func(chunkData []byte) error) error
-	// String forms an uploaded images table string from the store
-	String(limit uint32) string
+	// Save saves a new image streamed from the reader to the store and returns the name it is saved with.
+	// Image info provides name, type, source modification time, image properties, conflict policy and expiration time or time to live,
+	// on success actual name, path, size, creation and update time, checksum and version of the stored image are set to it by the store
+	// (both times are stamped by the store clock when the image is committed, times set by the caller are ignored).
+	// If image info has a checksum already the image data must match it,
+	// if image info has an expected version the existing image must have it.
+	Save(imageInfo *ImageInfo, imageData io.Reader) (string, error)
+	// Send sends the requested range of an existing image to the client from the store
+	Send(stream pb.ImageService_DownloadImageServer, imageName string, options SendOptions, send func(chunkData []byte) error) error
+	// String forms an uploaded images table string from the store (sorted by the field in the order before the limit is applied)
+	// followed by logical and physical usage of the store
+	String(limit uint32, sortField pb.SortField, sortOrder pb.SortOrder) string
+	// List returns info of images matching the options in the requested order
+	List(options ListOptions) []*ImageInfo
+	// Delete moves an existing image with its previous versions from the store to the trash
+	Delete(imageName string) error
+	// Find returns info of an existing image from the store
+	Find(imageName string) (*ImageInfo, error)
+	// CreateUploadSession opens a new resumable upload of the image described by image info
+	CreateUploadSession(imageInfo *ImageInfo) (*UploadSession, error)
+	// AppendUploadSession appends data streamed from the reader to the session starting from the offset,
+	// which must be equal to the committed offset of the session. Data read before a failure stays committed.
+	AppendUploadSession(sessionID string, offset int64, data io.Reader) (*UploadSession, error)
+	// FindUploadSession returns state of an existing upload session
+	FindUploadSession(sessionID string) (*UploadSession, error)
+	// OpenUploadSession returns state of an existing upload session and a reader of the data committed to it,
+	// the session can't be opened again until the reader is closed (FailedPrecondition error), so it is finalized once
+	OpenUploadSession(sessionID string) (*UploadSession, io.ReadCloser, error)
+	// DeleteUploadSession removes an upload session and its data
+	DeleteUploadSession(sessionID string) error
+	// CollectUploadSessions removes upload sessions idle since the time and returns their number
+	CollectUploadSessions(idleSince time.Time) (int, error)
+	// ListVersions returns info of the current and kept previous versions of an existing image from the newest one
+	ListVersions(imageName string) ([]*ImageInfo, error)
+	// RestoreVersion saves content of a kept version of the image as a new current version and returns its info
+	RestoreVersion(imageName string, version int64) (*ImageInfo, error)
+	// EvictExpired permanently removes images expired by the time with their previous versions and returns their number
+	EvictExpired(now time.Time) (int, error)
+	// Usage returns logical size of images, previous versions and deleted images and physical size of their content
+	Usage() StorageUsage
+	// ListTrash returns deleted images kept in the trash from the most recently deleted one
+	ListTrash() []*TrashItem
+	// RestoreTrashItem moves a deleted image with its previous versions back from the trash and returns its info
+	RestoreTrashItem(trashID string) (*ImageInfo, error)
+	// PurgeTrash permanently removes images deleted before the time from the trash and returns their number
+	PurgeTrash(deletedBefore time.Time) (int, error)
 }
 
-// DiskImageStore is a struct to store images on disk
+// prefixes of hidden service files in the image folder (leftovers are removed on startup)
+const (
+	uploadFilePrefix   = ".upload-"
+	replacedFilePrefix = ".replaced-"
+	deletedFilePrefix  = ".deleted-"
+)
+
+// DiskImageStore is a struct to store images on disk.
+// The mutex guards only in-memory images info, image files are guarded by per-image locks and blob files by per-checksum locks.
+// Disk I/O is never done under the mutex: changes of the catalog are committed to the index one at a time,
+// the catalog is only copied under the mutex and encoded and written to the index after it is released.
+// Image files are never modified in place (new data is renamed over the old file),
+// so an opened image file is a consistent snapshot and can be streamed without holding any lock.
 type DiskImageStore struct {
-	mutex       sync.RWMutex
-	imageFolder string
-	images      map[string]*ImageInfo
+	mutex        sync.RWMutex
+	imageLocks   imageLocks
+	sessionLocks imageLocks
+	blobLocks    imageLocks
+	imageFolder  string
+	images       map[string]*ImageInfo
+	sessions     map[string]*UploadSession
+	// previous versions of images from the oldest one
+	versions map[string][]*ImageInfo
+	// number of previous versions kept for every image
+	versionRetention int
+	// deleted images kept in the trash
+	trash map[string]*TrashItem
+	// content of images by checksum
+	blobs map[string]*blob
+	// identifiers of trash items being restored (they aren't purged meanwhile)
+	restoring map[string]bool
+	// clock stamping creation and update time of saved images and telling if images are expired
+	now func() time.Time
+	// serializes changes committed to the index (taken before the mutex)
+	indexMutex sync.Mutex
+}
+
+// DiskImageStoreOption is a function to configure DiskImageStore
+type DiskImageStoreOption func(store *DiskImageStore)
+
+// WithClock sets the clock stamping creation and update time of saved images and telling if images are expired (e.g. a fixed one for tests)
+func WithClock(now func() time.Time) DiskImageStoreOption {
+	return func(store *DiskImageStore) {
+		if now != nil {
+			store.now = now
+		}
+	}
 }
 
 // ImageInfo is a struct to operate information about image
 type ImageInfo struct {
-	ImageName string
-	Type      string
-	Path      string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ImageName string `json:"name"`
+	Type      string `json:"type"`
+	Path      string `json:"path"`
+	// creation and update time stamped by the store
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// modification time at the source reported by the client (zero if unknown)
+	SourceModifiedAt time.Time `json:"source_modified_at,omitempty"`
+	// modification time of the image file recorded by the store, so a file replaced behind its back is detected on startup
+	FileModifiedAt time.Time `json:"file_modified_at"`
+	// time after which the image is hidden and evicted from the store (zero means never)
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	// image size in bytes
+	Size int64 `json:"size"`
+	// image width and height in pixels
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// color model of the image (e.g. YCbCr, RGBA, Paletted)
+	ColorModel string `json:"color_model"`
+	// image format detected from the content (e.g. jpeg, png)
+	Format string `json:"format"`
+	// hex encoded SHA-256 of the image content
+	Checksum string `json:"checksum"`
+	// version of the image (incremented by every save)
+	Version int64 `json:"version"`
+	// what to do on save if an image with the name already exists and
+	// version the existing image must have on save (zero means any) - options of the save, aren't stored with the image
+	ConflictPolicy  pb.ConflictPolicy `json:"conflict_policy,omitempty"`
+	ExpectedVersion int64             `json:"expected_version,omitempty"`
+	// time to live counted from the moment the image is committed, replaces expiration time if set
+	// (zero means expiration time is kept as is) - option of the save, isn't stored with the image
+	TimeToLive time.Duration `json:"ttl,omitempty"`
 }
 
-// NewDiskImageStore returns a new DiskImageStore
-func NewDiskImageStore(imageFolder string) *DiskImageStore {
-	return &DiskImageStore{
-		imageFolder: imageFolder,
-		images:      make(map[string]*ImageInfo),
+// toInfo converts image info to the protobuf message
+func (imageInfo *ImageInfo) toInfo() *pb.Info {
+	return &pb.Info{
+		Name:             imageInfo.ImageName,
+		Type:             imageInfo.Type,
+		UpdatedAt:        timestamppb.New(imageInfo.UpdatedAt),
+		CreatedAt:        timestamppb.New(imageInfo.CreatedAt),
+		Size:             uint64(imageInfo.Size),
+		Width:            uint32(imageInfo.Width),
+		Height:           uint32(imageInfo.Height),
+		ColorModel:       imageInfo.ColorModel,
+		Format:           imageInfo.Format,
+		Checksum:         imageInfo.Checksum,
+		Version:          uint64(imageInfo.Version),
+		ConflictPolicy:   imageInfo.ConflictPolicy,
+		ExpectedVersion:  uint64(imageInfo.ExpectedVersion),
+		SourceModifiedAt: optionalTimestamp(imageInfo.SourceModifiedAt),
+		ExpiresAt:        optionalTimestamp(imageInfo.ExpiresAt),
+		Ttl:              optionalDuration(imageInfo.TimeToLive),
 	}
 }
 
-// Save saves a new image to the store
-func (store *DiskImageStore) Save(imageName string, imageType string, imageData bytes.Buffer, imageUpdateTime time.Time) (string, error) {
+// function to convert optional time to timestamp (zero time means unset timestamp)
+func optionalTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// function to convert optional duration to the protobuf message (zero duration means unset one)
+func optionalDuration(d time.Duration) *durationpb.Duration {
+	if d == 0 {
+		return nil
+	}
+	return durationpb.New(d)
+}
+
+// NewDiskImageStore returns a new DiskImageStore with images loaded from the index in image folder
+func NewDiskImageStore(imageFolder string, options ...DiskImageStoreOption) (*DiskImageStore, error) {
+	// trying to create image folder if it doesn't exist yet
+	err := os.MkdirAll(imageFolder, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create image folder: %w", err)
+	}
+
+	// trying to load images info and upload sessions saved by previous runs
+	index, err := loadImageIndex(imageFolder)
+	if err != nil {
+		return nil, err
+	}
+
+	// trying to bring the index up to date with image files actually lying in the folder
+	changed, err := scanImageFolder(imageFolder, index.Images)
+	if err != nil {
+		return nil, err
+	}
+
+	// trying to bring upload sessions up to date with the staging folder
+	sessionsChanged, err := scanStagingFolder(imageFolder, index.Sessions)
+	if err != nil {
+		return nil, err
+	}
+
+	// trying to bring previous versions up to date with the versions folder
+	versionsChanged, err := scanVersionsFolder(imageFolder, index.Images, index.Versions)
+	if err != nil {
+		return nil, err
+	}
+
+	// trying to bring trash items up to date with the trash folder
+	trashChanged, err := scanTrashFolder(imageFolder, index.Trash)
+	if err != nil {
+		return nil, err
+	}
+
+	if changed || sessionsChanged || versionsChanged || trashChanged {
+		err = saveImageIndex(imageFolder, index)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// trying to link files to blobs and count their references (blobs are derived from the index, so they aren't saved)
+	blobs, err := scanBlobsFolder(imageFolder, index)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &DiskImageStore{
+		imageFolder:      imageFolder,
+		images:           index.Images,
+		sessions:         index.Sessions,
+		versions:         index.Versions,
+		versionRetention: defaultVersionRetention,
+		trash:            index.Trash,
+		blobs:            blobs,
+		restoring:        make(map[string]bool),
+		now:              time.Now,
+	}
+	for _, option := range options {
+		option(store)
+	}
+
+	return store, nil
+}
+
+// commitIndex applies the change to images info, upload sessions, previous versions and trash items under the store lock
+// and persists the index, the change is reverted (under the store lock as well) if the index can't be written.
+// Commits are serialized, so a written index never contains a change reverted later,
+// the catalog is only copied under the store lock and encoded and written without holding it (caller must not hold the store lock).
+func (store *DiskImageStore) commitIndex(apply func(), revert func()) error {
+	store.indexMutex.Lock()
+	defer store.indexMutex.Unlock()
+
+	// apply the change and copy the catalog
+	store.mutex.Lock()
+	apply()
+	index := (&imageIndex{Images: store.images, Sessions: store.sessions, Versions: store.versions, Trash: store.trash}).copy()
+	store.mutex.Unlock()
+
+	// trying to persist the index, revert the change on failure
+	err := saveImageIndex(store.imageFolder, index)
+	if err != nil {
+		store.mutex.Lock()
+		revert()
+		store.mutex.Unlock()
+		return err
+	}
+
+	return nil
+}
+
+// Save streams a new image to a temporary file in the image folder and then commits it with an atomic rename,
+// so the image is locked only for the commit and readers never see a partially written image.
+// Before the commit the temporary file is linked to the blob with its content, so identical content is stored once.
+// The file of the previous image is kept aside until the index is persisted and removed (or kept as a version) after,
+// also if the previous image has another type.
+func (store *DiskImageStore) Save(newImageInfo *ImageInfo, imageData io.Reader) (string, error) {
+	imageType := newImageInfo.Type
+
+	// trying to write image data to a temporary file (without locking the store) computing its checksum on the fly
+	hash := sha256.New()
+	tmpPath, imageSize, err := writeTempFile(store.imageFolder, uploadFilePrefix, io.TeeReader(imageData, hash))
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpPath)
+
+	// check that received data matches declared checksum
+	imageChecksum := hex.EncodeToString(hash.Sum(nil))
+	if err := verifyChecksum(newImageInfo.Checksum, imageChecksum); err != nil {
+		return "", logError(err)
+	}
+
+	// trying to store the content as a blob (or link to the blob with the same content), release it if the save fails
+	err = store.acquireBlob(tmpPath, imageChecksum, imageSize)
+	if err != nil {
+		return "", err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			store.releaseBlob(imageChecksum)
+		}
+	}()
+
+	// lock image name to save the image with according to the conflict policy
+	imageName, unlock, err := store.lockImageName(newImageInfo.ImageName, newImageInfo.ConflictPolicy)
+	if err != nil {
+		return "", logError(err)
+	}
+	defer unlock()
+
 	// formatting image path string
 	imagePath := fmt.Sprintf("%s/%s%s", store.imageFolder, imageName, imageType)
 
-	// lock store
-	store.mutex.Lock()
-	defer store.mutex.Unlock()
+	// evict the expired image with the name, so the new image doesn't continue its history
+	_, err = store.evictLockedImage(imageName, store.now())
+	if err != nil {
+		return "", err
+	}
+
+	// get the current image (it can't be changed by others while the image is locked)
+	store.mutex.RLock()
+	previousImageInfo := store.images[imageName]
+	store.mutex.RUnlock()
 
-	// trying to create file
-	file, err := os.Create(imagePath)
+	// check that the image wasn't changed since the client read it
+	if err := verifyVersion(imageName, newImageInfo.ExpectedVersion, previousImageInfo); err != nil {
+		return "", logError(err)
+	}
+
+	// keep the previous image file linked aside until the index is persisted (as a version if history is kept)
+	backupPath, versioned, err := store.backupImage(previousImageInfo)
 	if err != nil {
-		return "", fmt.Errorf("cannot create image file: %w", err)
+		return "", err
 	}
 
-	// trying to write data to file
-	_, err = imageData.WriteTo(file)
+	// trying to replace image file with the new one
+	err = os.Rename(tmpPath, imagePath)
 	if err != nil {
-		return "", fmt.Errorf("cannot write image to file: %w", err)
+		if backupPath != "" {
+			os.Remove(backupPath)
+		}
+		return "", fmt.Errorf("cannot commit image file: %w", err)
 	}
 
 	// prepare image info to update in-memory storage value
-	imageInfo := &ImageInfo{
-		ImageName: imageName,
-		Type:      imageType,
-		Path:      imagePath,
-		UpdatedAt: imageUpdateTime,
+	imageInfo := savedImageInfo(newImageInfo, imageName, imageSize, imageChecksum, previousImageInfo, store.now())
+	imageInfo.Path = imagePath
+	if fileInfo, err := os.Stat(imagePath); err == nil {
+		imageInfo.FileModifiedAt = fileInfo.ModTime()
 	}
 
-	// check if image not exists
-	if store.images[imageName] == nil {
-		imageInfo.CreatedAt = imageUpdateTime
-	} else {
-		imageInfo.CreatedAt = store.images[imageName].CreatedAt
+	// trying to update in-memory storage value and history of the image and persist the index, restore previous image on failure
+	var previousVersions, droppedVersions []*ImageInfo
+	err = store.commitIndex(func() {
+		store.images[imageName] = imageInfo
+		previousVersions = store.versions[imageName]
+		if versioned {
+			versionInfo := *previousImageInfo
+			versionInfo.Path = backupPath
+			store.versions[imageName], droppedVersions = appendVersion(previousVersions, &versionInfo, store.versionRetention)
+		}
+	}, func() {
+		if previousImageInfo == nil {
+			delete(store.images, imageName)
+		} else {
+			store.images[imageName] = previousImageInfo
+		}
+		if previousVersions == nil {
+			delete(store.versions, imageName)
+		} else {
+			store.versions[imageName] = previousVersions
+		}
+	})
+	if err != nil {
+		if backupPath != "" && previousImageInfo.Type == imageType {
+			os.Rename(backupPath, imagePath)
+		} else {
+			os.Remove(imagePath)
+			if backupPath != "" {
+				os.Remove(backupPath)
+			}
+		}
+		return "", err
+	}
+	committed = true
+
+	// release content of the replaced image (unless it is kept as a version) and versions out of retention
+	if previousImageInfo != nil && !versioned {
+		store.releaseBlob(previousImageInfo.Checksum)
+	}
+	for _, versionInfo := range droppedVersions {
+		store.releaseBlob(versionInfo.Checksum)
+	}
+
+	// trying to remove files of the replaced image and versions out of retention
+	// (the name is the identity of the image, so a file of the previous type isn't left behind)
+	if backupPath != "" && !versioned {
+		os.Remove(backupPath)
+	}
+	if previousImageInfo != nil && previousImageInfo.Type != imageType {
+		previousImagePath := fmt.Sprintf("%s/%s%s", store.imageFolder, imageName, previousImageInfo.Type)
+		err = os.Remove(previousImagePath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("cannot remove replaced image file %s: %v", previousImagePath, err)
+		}
+	}
+	for _, versionInfo := range droppedVersions {
+		os.Remove(versionFilePath(store.imageFolder, imageName, versionInfo.Version, versionInfo.Type))
 	}
 
-	// update in-memory storage value
-	store.images[imageName] = imageInfo
+	// report fields set by the store back to the caller
+	*newImageInfo = *imageInfo
 
 	return imageName, nil
 }
 
-// Send sends image info first, then sends chunks of data one by one via send function
-func (store *DiskImageStore) Send(stream pb.ImageService_DownloadImageServer, imageName string, send func(chunkData []byte) error) error {
-	// lock in-memory storage
-	store.mutex.Lock()
-	defer store.mutex.Unlock()
+// savedImageInfo returns info of the image saved with the name, size and checksum over the previous image (nil if there is none):
+// save options are cleared (time to live is turned into expiration time), both times are stamped with the time (creation time is kept on overwrite) and version continues the previous image
+func savedImageInfo(newImageInfo *ImageInfo, imageName string, size int64, checksum string, previousImageInfo *ImageInfo, now time.Time) *ImageInfo {
+	imageInfo := &ImageInfo{}
+	*imageInfo = *newImageInfo
+	imageInfo.ImageName = imageName
+	imageInfo.ConflictPolicy = pb.ConflictPolicy_CONFLICT_POLICY_OVERWRITE
+	imageInfo.ExpectedVersion = 0
+	imageInfo.TimeToLive = 0
+	imageInfo.Size = size
+	imageInfo.Checksum = checksum
+
+	// stamp update time (times sent by the client aren't trusted) and check if image not exists
+	imageInfo.UpdatedAt = now
+	if newImageInfo.TimeToLive > 0 {
+		imageInfo.ExpiresAt = now.Add(newImageInfo.TimeToLive)
+	}
+	if previousImageInfo == nil {
+		imageInfo.CreatedAt = now
+		imageInfo.Version = 1
+	} else {
+		imageInfo.CreatedAt = previousImageInfo.CreatedAt
+		imageInfo.Version = previousImageInfo.Version + 1
+	}
+
+	return imageInfo
+}
+
+// lockImageName locks the name to save the image with according to the conflict policy
+// and returns the name with the function unlocking it
+func (store *DiskImageStore) lockImageName(imageName string, conflictPolicy pb.ConflictPolicy) (string, func(), error) {
+	for i := 0; ; i++ {
+		// form candidate name (name, name-1, name-2, ...)
+		candidateName, err := candidateImageName(imageName, i)
+		if err != nil {
+			return "", nil, err
+		}
+
+		// lock candidate name for writing and check if it is taken
+		unlock := store.imageLocks.Lock(candidateName)
+		store.mutex.RLock()
+		exists := store.liveImage(candidateName) != nil
+		store.mutex.RUnlock()
+
+		switch {
+		case !exists || conflictPolicy == pb.ConflictPolicy_CONFLICT_POLICY_OVERWRITE:
+			return candidateName, unlock, nil
+		case conflictPolicy == pb.ConflictPolicy_CONFLICT_POLICY_FAIL_IF_EXISTS:
+			unlock()
+			return "", nil, status.Errorf(codes.AlreadyExists, "image already exists: %v", imageName)
+		}
+		unlock()
+	}
+}
+
+// candidateImageName returns the i-th name to save the image with according to the auto rename conflict policy
+// (name, name-1, name-2, ...) or AlreadyExists error if the name becomes invalid
+func candidateImageName(imageName string, i int) (string, error) {
+	if i == 0 {
+		return imageName, nil
+	}
+
+	candidateName := fmt.Sprintf("%s-%d", imageName, i)
+	if ValidateImageName(candidateName) != nil {
+		return "", status.Errorf(codes.AlreadyExists, "cannot find a free name for image %s", imageName)
+	}
+	return candidateName, nil
+}
+
+// backupImage links file of the current image aside and returns path of the link (empty if there is no file)
+// and whether the link is a file of the previous version (the caller must hold the image lock)
+func (store *DiskImageStore) backupImage(imageInfo *ImageInfo) (string, bool, error) {
+	if imageInfo == nil {
+		return "", false, nil
+	}
 
+	// formatting paths of the image and its backup
+	imagePath := fmt.Sprintf("%s/%s%s", store.imageFolder, imageInfo.ImageName, imageInfo.Type)
+	backupPath := fmt.Sprintf("%s/%s%s%s", store.imageFolder, replacedFilePrefix, imageInfo.ImageName, imageInfo.Type)
+	versioned := store.versionRetention > 0
+	if versioned {
+		backupPath = versionFilePath(store.imageFolder, imageInfo.ImageName, imageInfo.Version, imageInfo.Type)
+		err := os.MkdirAll(filepath.Dir(backupPath), 0755)
+		if err != nil {
+			return "", false, fmt.Errorf("cannot create versions folder: %w", err)
+		}
+	}
+
+	// trying to link the image file (removing a leftover of an interrupted save first)
+	os.Remove(backupPath)
+	err := os.Link(imagePath, backupPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("cannot keep previous image file: %w", err)
+	}
+
+	return backupPath, versioned, nil
+}
+
+// verifyVersion returns FailedPrecondition error if the expected version is set and the current image has another one
+func verifyVersion(imageName string, expectedVersion int64, imageInfo *ImageInfo) error {
+	if expectedVersion == 0 {
+		return nil
+	}
+	if imageInfo == nil {
+		return status.Errorf(codes.FailedPrecondition, "image %s was deleted: expected version %d", imageName, expectedVersion)
+	}
+	if imageInfo.Version != expectedVersion {
+		return status.Errorf(codes.FailedPrecondition, "image %s was changed: expected version %d, image has version %d", imageName, expectedVersion, imageInfo.Version)
+	}
+	return nil
+}
+
+// verifyChecksum returns DataLoss error if the expected checksum is set and differs from the actual one
+func verifyChecksum(expectedChecksum string, actualChecksum string) error {
+	if expectedChecksum != "" && !strings.EqualFold(expectedChecksum, actualChecksum) {
+		return status.Errorf(codes.DataLoss, "image checksum mismatch: expected %s, received data has %s", expectedChecksum, actualChecksum)
+	}
+	return nil
+}
+
+// fileChecksum returns hex encoded SHA-256 of the file content
+func fileChecksum(path string) (string, error) {
+	// trying to open file
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	// trying to hash file content
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeTempFile writes data to a new hidden temporary file in the folder and flushes it to disk.
+// It returns path and size of the written file, the file is removed on failure.
+func writeTempFile(folder string, prefix string, data io.Reader) (string, int64, error) {
+	// trying to create temporary file
+	file, err := os.CreateTemp(folder, prefix+"*")
+	if err != nil {
+		return "", 0, fmt.Errorf("cannot create temporary file: %w", err)
+	}
+	tmpPath := file.Name()
+
+	// trying to write data to file chunk by chunk and flush it to disk
+	size, err := io.Copy(file, data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", 0, fmt.Errorf("cannot write temporary file: %w", err)
+	}
+
+	return tmpPath, size, nil
+}
+
+// SendOptions is a struct to operate range of the image to send
+type SendOptions struct {
+	// offset of the first byte to send
+	Offset int64
+	// maximum number of bytes to send (zero means up to the end of the image)
+	Length int64
+	// version of the image to send (zero means the current version)
+	Version int64
+	// checksum the image must have (empty means any)
+	ExpectedChecksum string
+	// checksum of the client copy (empty means no copy), takes precedence over IfModifiedSince
+	IfNoneMatch string
+	// update time of the client copy (zero means no copy)
+	IfModifiedSince time.Time
+}
+
+// notModified reports if the client copy of the image described by the options is current
+func (options SendOptions) notModified(imageInfo *ImageInfo) bool {
+	if options.IfNoneMatch != "" {
+		return strings.EqualFold(options.IfNoneMatch, imageInfo.Checksum)
+	}
+	if !options.IfModifiedSince.IsZero() {
+		return !imageInfo.UpdatedAt.After(options.IfModifiedSince)
+	}
+	return false
+}
+
+// Send sends image info first, then sends chunks of data of the requested range one by one via send function.
+// The image is locked only while its file is opened, so slow downloads don't block other requests.
+func (store *DiskImageStore) Send(stream pb.ImageService_DownloadImageServer, imageName string, options SendOptions, send func(chunkData []byte) error) error {
 	ctx := stream.Context()
 
 	// check for context error
@@ -106,41 +642,68 @@ func (store *DiskImageStore) Send(stream pb.ImageService_DownloadImageServer, im
 		return err
 	}
 
-	// if no image with such name return invalid argument error
-	imageInfo := store.images[imageName]
-	if imageInfo == nil {
-		return logError(status.Errorf(codes.InvalidArgument, "image doesn't exists: %v", imageName))
+	// trying to open image (under the image read lock, so info and file match each other)
+	unlock := store.imageLocks.RLock(imageName)
+	imageInfo, file, err := store.openImage(imageName, options.Version)
+	unlock()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return sendImage(stream, imageInfo, file, options, send)
+}
+
+// sendImage sends image info first, then sends chunks of the requested range of the image content one by one via send function
+func sendImage(stream pb.ImageService_DownloadImageServer, imageInfo *ImageInfo, content io.ReadSeeker, options SendOptions, send func(chunkData []byte) error) error {
+	imageName := imageInfo.ImageName
+
+	// check that the image wasn't changed since the client got its checksum
+	if options.ExpectedChecksum != "" && !strings.EqualFold(options.ExpectedChecksum, imageInfo.Checksum) {
+		return logError(status.Errorf(codes.FailedPrecondition, "image %s was changed: expected checksum %s, image has %s", imageName, options.ExpectedChecksum, imageInfo.Checksum))
+	}
+
+	// send only image info if the client copy is current
+	if options.notModified(imageInfo) {
+		info := imageInfo.toInfo()
+		info.NotModified = true
+		return stream.Send(&pb.DownloadImageResponse{
+			Data: &pb.DownloadImageResponse_Info{
+				Info: info,
+			},
+		})
+	}
+
+	// check that the range starts within the image
+	if options.Offset < 0 || options.Offset > imageInfo.Size {
+		return logError(status.Errorf(codes.OutOfRange, "offset %d is beyond size %d of image %s", options.Offset, imageInfo.Size, imageName))
+	}
+
+	// trying to seek to the beginning of the range
+	_, err := content.Seek(options.Offset, io.SeekStart)
+	if err != nil {
+		return logError(status.Errorf(codes.Internal, "cannot seek image content: %v", err))
 	}
 
 	// forming response with image info
 	res := &pb.DownloadImageResponse{
 		Data: &pb.DownloadImageResponse_Info{
-			Info: &pb.Info{
-				Name:      imageName,
-				Type:      imageInfo.Type,
-				UpdatedAt: timestamppb.New(imageInfo.UpdatedAt),
-			},
+			Info: imageInfo.toInfo(),
 		},
 	}
 
 	// trying to send response
-	err := stream.Send(res)
+	err = stream.Send(res)
 	if err != nil {
 		return err
 	}
 
-	// formatting image path string
-	imagePath := fmt.Sprintf("%s/%s%s", store.imageFolder, imageName, imageInfo.Type)
-
-	// trying to open image
-	file, err := os.Open(imagePath)
-	if err != nil {
-		return logError(status.Errorf(codes.Internal, "cannot open image file: %v", imagePath))
+	// init reader of the range and buffer
+	var rangeReader io.Reader = content
+	if options.Length > 0 {
+		rangeReader = io.LimitReader(content, options.Length)
 	}
-	defer file.Close()
-
-	// init reader and buffer
-	reader := bufio.NewReader(file)
+	reader := bufio.NewReader(rangeReader)
 	buffer := make([]byte, bufferSize)
 	for {
 		// if there is context error returns it
@@ -154,7 +717,7 @@ func (store *DiskImageStore) Send(stream pb.ImageService_DownloadImageServer, im
 			break
 		}
 		if err != nil {
-			logError(status.Errorf(codes.Internal, "cannot read chunk to buffer: %v", err))
+			return logError(status.Errorf(codes.Internal, "cannot read chunk to buffer: %v", err))
 		}
 
 		err = send(buffer[:n])
@@ -166,46 +729,181 @@ func (store *DiskImageStore) Send(stream pb.ImageService_DownloadImageServer, im
 	return nil
 }
 
-// String forms an uploaded images table string from the store
-func (store *DiskImageStore) String(limit uint32) string {
-	// lock in-memory storage
-	store.mutex.Lock()
-	defer store.mutex.Unlock()
+// openImage returns a copy of info and opened file of the image version (zero means the current version)
+// (caller must hold the image lock)
+func (store *DiskImageStore) openImage(imageName string, version int64) (*ImageInfo, *os.File, error) {
+	// trying to get image info copy and image path
+	imageInfo, imagePath, err := store.findVersion(imageName, version)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// if there is no limit set it as store length
-	storeLen := len(store.images)
-	if limit == uint32(0) {
-		limit = uint32(storeLen)
+	// trying to open image
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return nil, nil, logError(status.Errorf(codes.Internal, "cannot open image file: %v", imagePath))
 	}
 
-	// find string with maximal length (for space padding)
-	baseFullNameLen := utf8.RuneCountInString("Имя файла ")
-	maxFullNameLen := baseFullNameLen
-	i := uint32(0)
-	for imageName, imageInfo := range store.images {
-		if i >= limit {
-			break
+	return imageInfo, file, nil
+}
+
+// String forms an uploaded images table string from the store.
+// Images are sorted by the field in the order first and then the limit is applied.
+func (store *DiskImageStore) String(limit uint32, sortField pb.SortField, sortOrder pb.SortOrder) string {
+	// take sorted snapshot of images info
+	images := store.List(ListOptions{SortField: sortField, SortOrder: sortOrder})
+
+	return formatImagesTable(images, limit, store.Usage())
+}
+
+// layout of times in the images table string
+const imagesTableTimeLayout = "02.01.2006 15:04:05"
+
+// layout of the usage line following the images table string
+const imagesUsageLayout = "Логический размер: %d | Физический размер: %d\n"
+
+// headers of the images table string columns
+var imagesTableHeaders = []string{"Имя файла", "Дата создания", "Дата обновления", "Размер", "Разрешение", "Формат", "Цветовая модель"}
+
+// formatImagesTable forms table string with the first limit images (zero limit means all images) and usage of the store
+func formatImagesTable(images []*ImageInfo, limit uint32, usage StorageUsage) string {
+	// apply limit to sorted images
+	if limit != uint32(0) && uint32(len(images)) > limit {
+		images = images[:limit]
+	}
+
+	// form table cells
+	rows := [][]string{imagesTableHeaders}
+	for _, imageInfo := range images {
+		rows = append(rows, []string{
+			imageInfo.ImageName + imageInfo.Type,
+			imageInfo.CreatedAt.Local().Format(imagesTableTimeLayout),
+			imageInfo.UpdatedAt.Local().Format(imagesTableTimeLayout),
+			fmt.Sprintf("%d", imageInfo.Size),
+			fmt.Sprintf("%dx%d", imageInfo.Width, imageInfo.Height),
+			imageInfo.Format,
+			imageInfo.ColorModel,
+		})
+	}
+
+	// find cell with maximal length in each column (for space padding), date columns are always wide enough for a date
+	columnLens := make([]int, len(imagesTableHeaders))
+	columnLens[1] = len(imagesTableTimeLayout)
+	columnLens[2] = len(imagesTableTimeLayout)
+	for _, row := range rows {
+		for i, cell := range row {
+			if cellLen := utf8.RuneCountInString(cell); cellLen > columnLens[i] {
+				columnLens[i] = cellLen
+			}
 		}
-		if curFullNameLen := utf8.RuneCountInString(imageName) + utf8.RuneCountInString(imageInfo.Type); curFullNameLen > maxFullNameLen {
-			maxFullNameLen = curFullNameLen
+	}
+
+	// generate table string using calculated values (the last column isn't padded)
+	imagesTableString := strings.Builder{}
+	for _, row := range rows {
+		for i, cell := range row {
+			if i > 0 {
+				imagesTableString.WriteString(" | ")
+			}
+			imagesTableString.WriteString(cell)
+			if i < len(row)-1 {
+				imagesTableString.WriteString(strings.Repeat(" ", columnLens[i]-utf8.RuneCountInString(cell)))
+			}
 		}
-		i += uint32(1)
+		imagesTableString.WriteString("\n")
 	}
+	imagesTableString.WriteString(fmt.Sprintf(imagesUsageLayout, usage.Logical, usage.Physical))
 
-	// generate table string using calculated value
-	imagesTableString := "Имя файла " + strings.Repeat(" ", maxFullNameLen-baseFullNameLen) + "| Дата создания       | Дата обновления\n"
-	i = uint32(0)
-	fullName := ""
-	for imageName, imageInfo := range store.images {
-		if i >= limit {
-			break
+	return imagesTableString.String()
+}
+
+// List returns copies of images info matching the options in the requested order
+func (store *DiskImageStore) List(options ListOptions) []*ImageInfo {
+	// lock in-memory storage for reading
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return listImages(store.images, options, store.now())
+}
+
+// Delete moves image file, files of previous versions and image info from the store to the trash,
+// where they are kept until the image is restored or purged.
+// The files are moved first and moved back if the index without the image can't be persisted,
+// so a failure never leaves the index pointing to a missing file or a file missing from the index.
+func (store *DiskImageStore) Delete(imageName string) error {
+	// trying to generate trash item identifier
+	trashID, err := newRandomID()
+	if err != nil {
+		return fmt.Errorf("cannot generate trash item id: %w", err)
+	}
+
+	// lock image for writing
+	unlock := store.imageLocks.Lock(imageName)
+	defer unlock()
+
+	// get the image with its history (it can't be changed by others while the image is locked)
+	store.mutex.RLock()
+	imageInfo := store.liveImage(imageName)
+	versions := store.versions[imageName]
+	store.mutex.RUnlock()
+
+	// if no image with such name (or it is expired) return not found error
+	if imageInfo == nil {
+		return logError(status.Errorf(codes.NotFound, "image doesn't exists: %v", imageName))
+	}
+
+	// trying to move image file and files of previous versions to the folder of a new trash item
+	item := &TrashItem{
+		ID:        trashID,
+		ImageInfo: imageInfo,
+		Versions:  versions,
+		DeletedAt: time.Now(),
+	}
+	itemFolder := trashItemFolderPath(store.imageFolder, trashID)
+	err = os.MkdirAll(itemFolder, 0755)
+	if err != nil {
+		return fmt.Errorf("cannot create trash item folder: %w", err)
+	}
+	paths, trashPaths := trashItemPaths(store.imageFolder, item)
+	err = moveFiles(paths, trashPaths)
+	if err != nil {
+		os.RemoveAll(itemFolder)
+		return err
+	}
+
+	// trying to move the image with its history to the trash and persist the index, restore the image on failure
+	err = store.commitIndex(func() {
+		delete(store.images, imageName)
+		delete(store.versions, imageName)
+		store.trash[trashID] = item
+	}, func() {
+		store.images[imageName] = imageInfo
+		if item.Versions != nil {
+			store.versions[imageName] = item.Versions
 		}
+		delete(store.trash, trashID)
+	})
+	if err != nil {
+		moveFiles(trashPaths, paths)
+		os.RemoveAll(itemFolder)
+		return err
+	}
 
-		fullName = imageName + imageInfo.Type
+	return nil
+}
+
+// Find returns a copy of image info or not found error if there is no image with such name
+func (store *DiskImageStore) Find(imageName string) (*ImageInfo, error) {
+	// lock in-memory storage for reading
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
 
-		imagesTableString += fullName + strings.Repeat(" ", maxFullNameLen-utf8.RuneCountInString(fullName)) + "| " + fmt.Sprintf(imageInfo.CreatedAt.Local().Format("02.01.2006 15:04:05")) + " | " + fmt.Sprintf(imageInfo.UpdatedAt.Local().Format("02.01.2006 15:04:05")) + "\n"
-		i += uint32(1)
+	// if no image with such name (or it is expired) return not found error
+	imageInfo := store.liveImage(imageName)
+	if imageInfo == nil {
+		return nil, logError(status.Errorf(codes.NotFound, "image doesn't exists: %v", imageName))
 	}
 
-	return imagesTableString
+	imageInfoCopy := *imageInfo
+	return &imageInfoCopy, nil
 }
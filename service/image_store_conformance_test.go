@@ -0,0 +1,37 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/MrPark97/tages/service"
+	"github.com/MrPark97/tages/service/storetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskImageStoreConformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.Run(t, func(t *testing.T) service.ImageStore {
+		imageStore, err := service.NewDiskImageStore(t.TempDir())
+		require.NoError(t, err)
+		return imageStore
+	})
+}
+
+func TestDiskImageStoreWithoutVersionsConformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.Run(t, func(t *testing.T) service.ImageStore {
+		imageStore, err := service.NewDiskImageStore(t.TempDir(), service.WithVersionRetention(0))
+		require.NoError(t, err)
+		return imageStore
+	})
+}
+
+func TestMemoryImageStoreConformance(t *testing.T) {
+	t.Parallel()
+
+	storetest.Run(t, func(t *testing.T) service.ImageStore {
+		return service.NewMemoryImageStore()
+	})
+}
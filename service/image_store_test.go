@@ -0,0 +1,318 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MrPark97/tages/pb"
+	"github.com/MrPark97/tages/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDiskImageStoreReloadsIndex(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	updatedAt := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	imageStore, err := service.NewDiskImageStore(imageFolder, service.WithClock(func() time.Time { return updatedAt }))
+	require.NoError(t, err)
+
+	imageData, err := os.ReadFile("../tmp/laptop.jpeg")
+	require.NoError(t, err)
+
+	imageName, err := imageStore.Save(&service.ImageInfo{ImageName: "laptop", Type: ".jpeg"}, bytes.NewReader(imageData))
+	require.NoError(t, err)
+	require.Equal(t, "laptop", imageName)
+
+	// a leftover of an interrupted index write must not affect loading
+	err = os.WriteFile(filepath.Join(imageFolder, ".index.json.123.tmp"), []byte(`{"images": {"bro`), 0644)
+	require.NoError(t, err)
+
+	reloadedStore, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+
+	table := reloadedStore.String(0, pb.SortField_SORT_FIELD_UNSPECIFIED, pb.SortOrder_SORT_ORDER_ASC)
+	require.Equal(t, 3, strings.Count(table, "\n"))
+	require.Contains(t, table, "laptop.jpeg")
+	require.Contains(t, table, updatedAt.Local().Format("02.01.2006 15:04:05"))
+}
+
+func TestDiskImageStoreRejectsCorruptedIndex(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	err := os.WriteFile(filepath.Join(imageFolder, ".index.json"), []byte("not json"), 0644)
+	require.NoError(t, err)
+
+	_, err = service.NewDiskImageStore(imageFolder)
+	require.Error(t, err)
+}
+
+func TestDiskImageStoreRevertsUnpersistedChange(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	imageStore, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+	saveTestImage(t, imageStore, "laptop", "../tmp/laptop.jpeg")
+
+	imageData, err := os.ReadFile("../tmp/macbook.png")
+	require.NoError(t, err)
+
+	// the index can't be replaced while a folder lies in its place
+	indexPath := filepath.Join(imageFolder, ".index.json")
+	require.NoError(t, os.Rename(indexPath, indexPath+".bak"))
+	require.NoError(t, os.MkdirAll(filepath.Join(indexPath, "blocker"), 0755))
+	_, err = imageStore.Save(&service.ImageInfo{ImageName: "macbook", Type: ".png"}, bytes.NewReader(imageData))
+	require.Error(t, err)
+	_, err = imageStore.Find("macbook")
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// the next change is persisted without the reverted one
+	require.NoError(t, os.RemoveAll(indexPath))
+	require.NoError(t, os.Rename(indexPath+".bak", indexPath))
+	saveTestImage(t, imageStore, "notebook", "../tmp/macbook.png")
+
+	reloadedStore, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+	_, err = reloadedStore.Find("macbook")
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = reloadedStore.Find("notebook")
+	require.NoError(t, err)
+	_, err = reloadedStore.Find("laptop")
+	require.NoError(t, err)
+}
+
+func TestDiskImageStoreScansImageFolder(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	copyTestFile(t, "../tmp/laptop.jpeg", filepath.Join(imageFolder, "laptop.jpeg"))
+	copyTestFile(t, "../tmp/macbook.png", filepath.Join(imageFolder, "macbook.png"))
+	err := os.WriteFile(filepath.Join(imageFolder, "notes.txt"), []byte("not an image"), 0644)
+	require.NoError(t, err)
+
+	modifiedAt := time.Date(2022, 5, 6, 7, 8, 9, 0, time.UTC)
+	err = os.Chtimes(filepath.Join(imageFolder, "macbook.png"), modifiedAt, modifiedAt)
+	require.NoError(t, err)
+
+	imageStore, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+
+	table := imageStore.String(0, pb.SortField_SORT_FIELD_UNSPECIFIED, pb.SortOrder_SORT_ORDER_ASC)
+	require.Equal(t, 4, strings.Count(table, "\n"))
+	require.Contains(t, table, "laptop.jpeg")
+	require.Contains(t, table, "macbook.png")
+	require.Contains(t, table, modifiedAt.Local().Format("02.01.2006 15:04:05"))
+	require.NotContains(t, table, "notes")
+
+	// image properties are decoded from the files
+	images := imageStore.List(service.ListOptions{})
+	require.Len(t, images, 2)
+	require.Equal(t, "macbook", images[1].ImageName)
+	require.Equal(t, 745, images[1].Width)
+	require.Equal(t, 401, images[1].Height)
+	require.Equal(t, "png", images[1].Format)
+	require.Equal(t, "Paletted", images[1].ColorModel)
+	require.EqualValues(t, 68211, images[1].Size)
+
+	// image removed from the folder disappears, restored one is found again
+	err = os.Remove(filepath.Join(imageFolder, "laptop.jpeg"))
+	require.NoError(t, err)
+	copyTestFile(t, "../tmp/laptop.jpeg", filepath.Join(imageFolder, "notebook.jpeg"))
+
+	imageStore, err = service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+
+	table = imageStore.String(0, pb.SortField_SORT_FIELD_UNSPECIFIED, pb.SortOrder_SORT_ORDER_ASC)
+	require.Equal(t, 4, strings.Count(table, "\n"))
+	require.NotContains(t, table, "laptop.jpeg")
+	require.Contains(t, table, "notebook.jpeg")
+}
+
+func TestDiskImageStoreRefreshesReplacedFiles(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := filepath.Join(t.TempDir(), "img")
+	imageStore, err := service.NewDiskImageStore(imageFolder)
+	require.NoError(t, err)
+	saveTestImage(t, imageStore, "macbook", "../tmp/macbook.png")
+	savedInfo, err := imageStore.Find("macbook")
+	require.NoError(t, err)
+
+	// the file is replaced with other content of the same size (the header stays the same)
+	imageData, err := os.ReadFile("../tmp/macbook.png")
+	require.NoError(t, err)
+	imageData[len(imageData)-100] ^= 0xff
+	replacedPath := filepath.Join(imageFolder, "replacement.tmp")
+	require.NoError(t, os.WriteFile(replacedPath, imageData, 0644))
+	modifiedAt := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(replacedPath, modifiedAt, modifiedAt))
+	require.NoError(t, os.Rename(replacedPath, filepath.Join(imageFolder, "macbook.png")))
+
+	// the store is moved to another folder
+	movedFolder := filepath.Join(filepath.Dir(imageFolder), "moved")
+	require.NoError(t, os.Rename(imageFolder, movedFolder))
+
+	reloadedStore, err := service.NewDiskImageStore(movedFolder)
+	require.NoError(t, err)
+	imageInfo, err := reloadedStore.Find("macbook")
+	require.NoError(t, err)
+	checksum := sha256.Sum256(imageData)
+	require.Equal(t, hex.EncodeToString(checksum[:]), imageInfo.Checksum)
+	require.NotEqual(t, savedInfo.Checksum, imageInfo.Checksum)
+	require.Equal(t, filepath.Join(movedFolder, "macbook.png"), imageInfo.Path)
+	require.Equal(t, 745, imageInfo.Width)
+	require.True(t, modifiedAt.Equal(imageInfo.UpdatedAt))
+	require.Equal(t, imageData, downloadTestImage(t, reloadedStore, "macbook"))
+}
+
+func copyTestFile(t *testing.T, srcPath string, dstPath string) {
+	data, err := os.ReadFile(srcPath)
+	require.NoError(t, err)
+	err = os.WriteFile(dstPath, data, 0644)
+	require.NoError(t, err)
+}
+
+func TestDiskImageStoreSlowDownloadDoesNotBlock(t *testing.T) {
+	t.Parallel()
+
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+	saveTestImage(t, imageStore, "laptop", "../tmp/laptop.jpeg")
+	saveTestImage(t, imageStore, "macbook", "../tmp/macbook.png")
+
+	laptopData, err := os.ReadFile("../tmp/laptop.jpeg")
+	require.NoError(t, err)
+	macbookData, err := os.ReadFile("../tmp/macbook.png")
+	require.NoError(t, err)
+
+	// start a download which stalls after the first chunk
+	started := make(chan struct{})
+	release := make(chan struct{})
+	slowDownloadDone := make(chan error)
+	slowDownloadData := bytes.Buffer{}
+	go func() {
+		firstChunk := true
+		slowDownloadDone <- imageStore.Send(newTestDownloadStream(), "laptop", service.SendOptions{}, func(chunkData []byte) error {
+			slowDownloadData.Write(chunkData)
+			if firstChunk {
+				firstChunk = false
+				close(started)
+				<-release
+			}
+			return nil
+		})
+	}()
+	<-started
+
+	// downloads of the same and other images, listing and uploads proceed meanwhile
+	requireDone(t, func() {
+		require.Equal(t, macbookData, downloadTestImage(t, imageStore, "macbook"))
+		require.Equal(t, laptopData, downloadTestImage(t, imageStore, "laptop"))
+		require.Len(t, imageStore.List(service.ListOptions{}), 2)
+		saveTestImage(t, imageStore, "laptop", "../tmp/macbook.png")
+		require.Equal(t, macbookData, downloadTestImage(t, imageStore, "laptop"))
+	})
+
+	// the stalled download finishes with the content it started with
+	close(release)
+	require.NoError(t, <-slowDownloadDone)
+	require.Equal(t, laptopData, slowDownloadData.Bytes())
+}
+
+func TestDiskImageStoreConcurrentAccess(t *testing.T) {
+	t.Parallel()
+
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	imageData, err := os.ReadFile("../tmp/laptop.jpeg")
+	require.NoError(t, err)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		imageName := []string{"laptop", "notebook"}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				_, err := imageStore.Save(&service.ImageInfo{ImageName: imageName, Type: ".jpeg"}, bytes.NewReader(imageData))
+				require.NoError(t, err)
+
+				// download may only fail with not found if another goroutine has just deleted the image
+				data := bytes.Buffer{}
+				err = imageStore.Send(newTestDownloadStream(), imageName, service.SendOptions{}, func(chunkData []byte) error {
+					data.Write(chunkData)
+					return nil
+				})
+				if err == nil {
+					require.Equal(t, imageData, data.Bytes())
+				} else {
+					require.Equal(t, codes.NotFound, status.Code(err))
+				}
+
+				imageStore.List(service.ListOptions{})
+				imageStore.String(1, pb.SortField_SORT_FIELD_SIZE, pb.SortOrder_SORT_ORDER_DESC)
+				imageStore.Delete(imageName)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// testDownloadStream is a DownloadImage stream which only collects sent image info
+type testDownloadStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	infos []*pb.Info
+}
+
+func newTestDownloadStream() *testDownloadStream {
+	return &testDownloadStream{ctx: context.Background()}
+}
+
+func (stream *testDownloadStream) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *testDownloadStream) Send(res *pb.DownloadImageResponse) error {
+	stream.infos = append(stream.infos, res.GetInfo())
+	return nil
+}
+
+func downloadTestImage(t *testing.T, imageStore service.ImageStore, imageName string) []byte {
+	imageData := bytes.Buffer{}
+	err := imageStore.Send(newTestDownloadStream(), imageName, service.SendOptions{}, func(chunkData []byte) error {
+		imageData.Write(chunkData)
+		return nil
+	})
+	require.NoError(t, err)
+
+	return imageData.Bytes()
+}
+
+func requireDone(t *testing.T, f func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "operation is blocked")
+	}
+}
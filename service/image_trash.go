@@ -0,0 +1,322 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/MrPark97/tages/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// name of the folder in image folder keeping files of deleted images until they are purged
+const trashFolderName = ".trash"
+
+// TrashItem is a struct to operate a deleted image kept in the trash.
+// Files of the item lie in its own folder of the trash: the image file and the folder with files of previous versions.
+type TrashItem struct {
+	ID string `json:"id"`
+	// info of the deleted image
+	ImageInfo *ImageInfo `json:"image_info"`
+	// previous versions of the deleted image from the oldest one
+	Versions  []*ImageInfo `json:"versions,omitempty"`
+	DeletedAt time.Time    `json:"deleted_at"`
+}
+
+// toTrashItem converts trash item to the protobuf message
+func (item *TrashItem) toTrashItem() *pb.TrashItem {
+	return &pb.TrashItem{
+		Id:        item.ID,
+		Info:      item.ImageInfo.toInfo(),
+		DeletedAt: timestamppb.New(item.DeletedAt),
+	}
+}
+
+// function to get path of the folder with files of the trash item
+func trashItemFolderPath(imageFolder string, trashID string) string {
+	return filepath.Join(imageFolder, trashFolderName, trashID)
+}
+
+// function to get paths of the image file and the folder of previous versions of the image
+// and their paths in the trash item folder
+func trashItemPaths(imageFolder string, item *TrashItem) ([]string, []string) {
+	imageName := item.ImageInfo.ImageName
+	itemFolder := trashItemFolderPath(imageFolder, item.ID)
+
+	paths := []string{
+		filepath.Join(imageFolder, imageName+item.ImageInfo.Type),
+		versionFolderPath(imageFolder, imageName),
+	}
+	trashPaths := []string{
+		filepath.Join(itemFolder, imageName+item.ImageInfo.Type),
+		filepath.Join(itemFolder, versionsFolderName),
+	}
+
+	return paths, trashPaths
+}
+
+// function to move files to the new paths (missing files are skipped),
+// on failure files which are already moved are moved back
+func moveFiles(paths []string, newPaths []string) error {
+	moved := make([]int, 0, len(paths))
+	for i := range paths {
+		err := os.Rename(paths[i], newPaths[i])
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			for _, j := range moved {
+				os.Rename(newPaths[j], paths[j])
+			}
+			return fmt.Errorf("cannot move file %s: %w", paths[i], err)
+		}
+		moved = append(moved, i)
+	}
+
+	return nil
+}
+
+// function to copy trash item with info of its image
+func copyTrashItem(item *TrashItem) *TrashItem {
+	itemCopy := *item
+	imageInfoCopy := *item.ImageInfo
+	itemCopy.ImageInfo = &imageInfoCopy
+	itemCopy.Versions = nil
+	return &itemCopy
+}
+
+// ListTrash returns copies of deleted images kept in the trash from the most recently deleted one
+func (store *DiskImageStore) ListTrash() []*TrashItem {
+	// lock in-memory storage for reading
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return listTrashItems(store.trash)
+}
+
+// listTrashItems returns copies of trash items from the most recently deleted one (caller must hold the lock of the trash)
+func listTrashItems(trash map[string]*TrashItem) []*TrashItem {
+	items := make([]*TrashItem, 0, len(trash))
+	for _, item := range trash {
+		items = append(items, copyTrashItem(item))
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].DeletedAt.Equal(items[j].DeletedAt) {
+			return items[i].DeletedAt.After(items[j].DeletedAt)
+		}
+		return items[i].ID < items[j].ID
+	})
+
+	return items
+}
+
+// RestoreTrashItem moves files of the deleted image and its previous versions back from the trash
+// and returns info of the restored image. An image with the same name must not exist.
+func (store *DiskImageStore) RestoreTrashItem(trashID string) (*ImageInfo, error) {
+	// find the item to know name of its image
+	store.mutex.RLock()
+	item := store.trash[trashID]
+	store.mutex.RUnlock()
+	if item == nil {
+		return nil, logError(status.Errorf(codes.NotFound, "trash item doesn't exists: %v", trashID))
+	}
+	imageName := item.ImageInfo.ImageName
+
+	// lock image for writing
+	unlock := store.imageLocks.Lock(imageName)
+	defer unlock()
+
+	// evict the expired image with the name, so it doesn't take the name
+	_, err := store.evictLockedImage(imageName, store.now())
+	if err != nil {
+		return nil, err
+	}
+
+	// check that the item isn't purged meanwhile and the name is free (it can't be taken by others while the image is locked),
+	// mark the item as being restored so it isn't purged while its files are moved
+	store.mutex.Lock()
+	if store.trash[trashID] != item {
+		store.mutex.Unlock()
+		return nil, logError(status.Errorf(codes.NotFound, "trash item doesn't exists: %v", trashID))
+	}
+	if store.images[imageName] != nil {
+		store.mutex.Unlock()
+		return nil, logError(status.Errorf(codes.AlreadyExists, "image already exists: %v", imageName))
+	}
+	store.restoring[trashID] = true
+	store.mutex.Unlock()
+	defer func() {
+		store.mutex.Lock()
+		delete(store.restoring, trashID)
+		store.mutex.Unlock()
+	}()
+
+	// trying to move files back (history of the name is kept only while the image exists, so leftovers are removed)
+	paths, trashPaths := trashItemPaths(store.imageFolder, item)
+	err = os.MkdirAll(filepath.Join(store.imageFolder, versionsFolderName), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create versions folder: %w", err)
+	}
+	os.RemoveAll(versionFolderPath(store.imageFolder, imageName))
+	err = moveFiles(trashPaths, paths)
+	if err != nil {
+		return nil, err
+	}
+
+	// trying to move the image with its history back from the trash and persist the index, move files to the trash again on failure
+	err = store.commitIndex(func() {
+		store.images[imageName] = item.ImageInfo
+		if len(item.Versions) > 0 {
+			store.versions[imageName] = item.Versions
+		}
+		delete(store.trash, trashID)
+	}, func() {
+		delete(store.images, imageName)
+		delete(store.versions, imageName)
+		store.trash[trashID] = item
+	})
+	if err != nil {
+		moveFiles(paths, trashPaths)
+		return nil, err
+	}
+
+	// trying to remove the emptied folder of the item
+	err = os.RemoveAll(trashItemFolderPath(store.imageFolder, trashID))
+	if err != nil {
+		log.Printf("cannot remove folder of restored trash item %s: %v", trashID, err)
+	}
+
+	imageInfoCopy := *item.ImageInfo
+	return &imageInfoCopy, nil
+}
+
+// PurgeTrash permanently removes images deleted before the time from the trash and returns their number
+func (store *DiskImageStore) PurgeTrash(deletedBefore time.Time) (int, error) {
+	// trying to remove the items from the index
+	purged, err := store.removeTrashItems(deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	// trying to remove files of the items (nobody can reach them once they are gone from the index)
+	for _, item := range purged {
+		err = os.RemoveAll(trashItemFolderPath(store.imageFolder, item.ID))
+		if err != nil {
+			log.Printf("cannot remove files of purged trash item %s: %v", item.ID, err)
+			continue
+		}
+		log.Printf("purged image %s deleted at %v", item.ImageInfo.ImageName, item.DeletedAt)
+	}
+
+	return len(purged), nil
+}
+
+// removeTrashItems removes items deleted before the time from the trash (except items being restored) and persists the index
+func (store *DiskImageStore) removeTrashItems(deletedBefore time.Time) ([]*TrashItem, error) {
+	// check that there are items to remove (the index isn't written in vain)
+	store.mutex.RLock()
+	found := false
+	for trashID, item := range store.trash {
+		if item.DeletedAt.Before(deletedBefore) && !store.restoring[trashID] {
+			found = true
+			break
+		}
+	}
+	store.mutex.RUnlock()
+	if !found {
+		return nil, nil
+	}
+
+	// trying to remove the items and persist the index, restore the items on failure
+	var purged []*TrashItem
+	err := store.commitIndex(func() {
+		for trashID, item := range store.trash {
+			if item.DeletedAt.Before(deletedBefore) && !store.restoring[trashID] {
+				purged = append(purged, item)
+				delete(store.trash, trashID)
+			}
+		}
+	}, func() {
+		for _, item := range purged {
+			store.trash[item.ID] = item
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// release content of the items
+	for _, item := range purged {
+		store.releaseImageBlobs(item.ImageInfo, item.Versions)
+	}
+
+	return purged, nil
+}
+
+// scanTrashFolder reconciles trash items with files lying in the trash folder:
+// items with malformed identifiers or missing image files are dropped, so are previous versions with missing files,
+// and folders of items unknown to the index are removed.
+// It returns true if trash items were changed.
+func scanTrashFolder(imageFolder string, trash map[string]*TrashItem) (bool, error) {
+	// trying to create trash folder if it doesn't exist yet
+	trashFolder := filepath.Join(imageFolder, trashFolderName)
+	err := os.MkdirAll(trashFolder, 0755)
+	if err != nil {
+		return false, fmt.Errorf("cannot create trash folder: %w", err)
+	}
+
+	changed := false
+
+	// drop items which can't be restored
+	for trashID, item := range trash {
+		if !randomIDPattern.MatchString(trashID) {
+			log.Printf("trash item id %q is malformed, removing it from the index", trashID)
+			delete(trash, trashID)
+			changed = true
+			continue
+		}
+
+		_, trashPaths := trashItemPaths(imageFolder, item)
+		if _, err := os.Stat(trashPaths[0]); err != nil {
+			log.Printf("file of deleted image %s is missing, removing trash item %s from the index", item.ImageInfo.ImageName, trashID)
+			delete(trash, trashID)
+			changed = true
+			continue
+		}
+
+		keptVersions := make([]*ImageInfo, 0, len(item.Versions))
+		for _, versionInfo := range item.Versions {
+			if _, err := os.Stat(filepath.Join(trashPaths[1], fmt.Sprintf("%d%s", versionInfo.Version, versionInfo.Type))); err == nil {
+				keptVersions = append(keptVersions, versionInfo)
+			}
+		}
+		if len(keptVersions) != len(item.Versions) {
+			log.Printf("%d versions of deleted image %s are missing, removing them from the index", len(item.Versions)-len(keptVersions), item.ImageInfo.ImageName)
+			item.Versions = keptVersions
+			changed = true
+		}
+	}
+
+	// trying to read trash folder entries
+	entries, err := os.ReadDir(trashFolder)
+	if err != nil {
+		return false, fmt.Errorf("cannot read trash folder: %w", err)
+	}
+
+	// remove files of items unknown to the index
+	for _, entry := range entries {
+		if trash[entry.Name()] == nil {
+			log.Printf("removing abandoned trash item %s", entry.Name())
+			os.RemoveAll(filepath.Join(trashFolder, entry.Name()))
+		}
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,137 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/MrPark97/tages/pb"
+	"github.com/MrPark97/tages/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDiskImageStoreTrash(t *testing.T) {
+	t.Parallel()
+
+	testImageFolder := t.TempDir()
+	imageStore, err := service.NewDiskImageStore(testImageFolder)
+	require.NoError(t, err)
+
+	// save 2 versions of the image
+	imagesData := [][]byte{randomPNG(t, 8, 8), randomPNG(t, 16, 16)}
+	for _, imageData := range imagesData {
+		_, err = imageStore.Save(&service.ImageInfo{ImageName: "noise", Type: ".png"}, bytes.NewReader(imageData))
+		require.NoError(t, err)
+	}
+
+	// deleted image is hidden from the store
+	err = imageStore.Delete("noise")
+	require.NoError(t, err)
+	_, err = imageStore.Find("noise")
+	require.Equal(t, codes.NotFound, status.Code(err))
+	err = imageStore.Send(newTestDownloadStream(), "noise", service.SendOptions{}, func(chunkData []byte) error {
+		return nil
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	require.Empty(t, imageStore.List(service.ListOptions{}))
+	require.NoFileExists(t, testImageFolder+"/noise.png")
+
+	// the trash survives the store restart
+	imageStore, err = service.NewDiskImageStore(testImageFolder)
+	require.NoError(t, err)
+	items := imageStore.ListTrash()
+	require.Len(t, items, 1)
+	require.Equal(t, "noise", items[0].ImageInfo.ImageName)
+	require.EqualValues(t, 2, items[0].ImageInfo.Version)
+
+	report, err := imageStore.CheckConsistency()
+	require.NoError(t, err)
+	require.True(t, report.Consistent())
+
+	// the name can't be restored while it is taken
+	_, err = imageStore.Save(&service.ImageInfo{ImageName: "noise", Type: ".png"}, bytes.NewReader(randomPNG(t, 4, 4)))
+	require.NoError(t, err)
+	_, err = imageStore.RestoreTrashItem(items[0].ID)
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	// restored image comes back with its history
+	err = imageStore.Delete("noise")
+	require.NoError(t, err)
+	imageInfo, err := imageStore.RestoreTrashItem(items[0].ID)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, imageInfo.Version)
+	require.Equal(t, imagesData[1], downloadTestImage(t, imageStore, "noise"))
+	require.Equal(t, imagesData[0], downloadTestImageVersion(t, imageStore, "noise", 1))
+	require.Len(t, imageStore.ListTrash(), 1)
+
+	_, err = imageStore.RestoreTrashItem(items[0].ID)
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestJanitorPurgesTrash(t *testing.T) {
+	t.Parallel()
+
+	testImageFolder := t.TempDir()
+	imageStore, err := service.NewDiskImageStore(testImageFolder)
+	require.NoError(t, err)
+
+	_, err = imageStore.Save(&service.ImageInfo{ImageName: "noise", Type: ".png"}, bytes.NewReader(randomPNG(t, 8, 8)))
+	require.NoError(t, err)
+	err = imageStore.Delete("noise")
+	require.NoError(t, err)
+
+	janitor := service.NewJanitor(imageStore)
+	janitor.TrashRetention = time.Hour
+
+	// the image isn't deleted long enough ago yet
+	janitor.Collect(time.Now())
+	require.Len(t, imageStore.ListTrash(), 1)
+
+	janitor.Collect(time.Now().Add(2 * time.Hour))
+	require.Empty(t, imageStore.ListTrash())
+	entries, err := os.ReadDir(testImageFolder + "/.trash")
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestClientRestoreImage(t *testing.T) {
+	t.Parallel()
+
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	serverAddress := startTestImageServer(t, imageStore)
+	imageClient := newTestImageClient(t, serverAddress)
+
+	imageData := randomPNG(t, 8, 8)
+	_, err = uploadTestImage(t, imageClient, &pb.Info{Name: "noise", Type: ".png"}, imageData)
+	require.NoError(t, err)
+
+	_, err = imageClient.DeleteImage(context.Background(), &pb.DeleteImageRequest{Name: "noise"})
+	require.NoError(t, err)
+
+	tableRes, err := imageClient.GetUploadedImagesTableString(context.Background(), &pb.GetUploadedImagesTableStringRequest{})
+	require.NoError(t, err)
+	require.NotContains(t, tableRes.GetTable(), "noise.png")
+
+	listRes, err := imageClient.ListTrash(context.Background(), &pb.ListTrashRequest{})
+	require.NoError(t, err)
+	require.Len(t, listRes.GetItems(), 1)
+	require.Equal(t, "noise", listRes.GetItems()[0].GetInfo().GetName())
+	require.NotNil(t, listRes.GetItems()[0].GetDeletedAt())
+
+	restoreRes, err := imageClient.RestoreImage(context.Background(), &pb.RestoreImageRequest{Id: listRes.GetItems()[0].GetId()})
+	require.NoError(t, err)
+	require.Equal(t, "noise", restoreRes.GetInfo().GetName())
+
+	_, downloadedData, err := downloadTestImageRange(imageClient, &pb.DownloadImageRequest{Name: "noise"})
+	require.NoError(t, err)
+	require.Equal(t, imageData, downloadedData)
+
+	_, err = imageClient.RestoreImage(context.Background(), &pb.RestoreImageRequest{Id: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -0,0 +1,409 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/MrPark97/tages/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// name of the folder in image folder keeping data of unfinished upload sessions
+const stagingFolderName = ".staging"
+
+// pattern of identifiers of upload sessions and trash items (random 128 bits in hex)
+var randomIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// UploadSession is a struct to operate state of a resumable upload.
+// Committed offset and time of the last append aren't persisted in the index,
+// they are taken from the staged data file, so they are always in sync with the data.
+type UploadSession struct {
+	ID string `json:"id"`
+	// info of the image being uploaded (name, type, source modification time, conflict policy, optional checksum, expected version and expiration time or time to live)
+	ImageInfo *ImageInfo `json:"image_info"`
+	CreatedAt time.Time  `json:"created_at"`
+	// number of bytes committed to the session
+	Offset int64 `json:"-"`
+	// time of the last append to the session
+	UpdatedAt time.Time `json:"-"`
+	// set while the data of the session is opened for finalizing, so the session isn't finalized twice
+	finalizing bool
+}
+
+// toUploadSession converts upload session to the protobuf message
+func (session *UploadSession) toUploadSession() *pb.UploadSession {
+	return &pb.UploadSession{
+		Id:              session.ID,
+		Info:            session.ImageInfo.toInfo(),
+		CommittedOffset: uint64(session.Offset),
+		CreatedAt:       timestamppb.New(session.CreatedAt),
+		UpdatedAt:       timestamppb.New(session.UpdatedAt),
+	}
+}
+
+// function to generate a new random identifier of an upload session or a trash item
+func newRandomID() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// function to copy info of the image being uploaded keeping only fields the client may set
+func sessionImageInfo(imageInfo *ImageInfo) *ImageInfo {
+	return &ImageInfo{
+		ImageName:        imageInfo.ImageName,
+		Type:             imageInfo.Type,
+		SourceModifiedAt: imageInfo.SourceModifiedAt,
+		Checksum:         imageInfo.Checksum,
+		ConflictPolicy:   imageInfo.ConflictPolicy,
+		ExpectedVersion:  imageInfo.ExpectedVersion,
+		ExpiresAt:        imageInfo.ExpiresAt,
+		TimeToLive:       imageInfo.TimeToLive,
+	}
+}
+
+// function to get path of the file with data of the upload session
+func stagingFilePath(imageFolder string, sessionID string) string {
+	return filepath.Join(imageFolder, stagingFolderName, sessionID)
+}
+
+// CreateUploadSession creates an empty staged data file and persists a new upload session in the index
+func (store *DiskImageStore) CreateUploadSession(imageInfo *ImageInfo) (*UploadSession, error) {
+	// trying to generate session identifier
+	sessionID, err := newRandomID()
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate upload session id: %w", err)
+	}
+
+	// trying to create staged data file (the staging folder is created on startup, but may be removed since)
+	stagingPath := stagingFilePath(store.imageFolder, sessionID)
+	err = os.MkdirAll(filepath.Dir(stagingPath), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create staging folder: %w", err)
+	}
+	file, err := os.OpenFile(stagingPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create upload session file: %w", err)
+	}
+	file.Close()
+
+	// prepare session
+	session := &UploadSession{
+		ID:        sessionID,
+		ImageInfo: sessionImageInfo(imageInfo),
+		CreatedAt: time.Now(),
+	}
+
+	// trying to add the session and persist the index, remove the session on failure
+	err = store.commitIndex(func() {
+		store.sessions[sessionID] = session
+	}, func() {
+		delete(store.sessions, sessionID)
+	})
+	if err != nil {
+		os.Remove(stagingPath)
+		return nil, err
+	}
+
+	sessionCopy := copySession(session)
+	sessionCopy.UpdatedAt = session.CreatedAt
+
+	return sessionCopy, nil
+}
+
+// AppendUploadSession appends data to the staged data file of the session.
+// Appends to the same session are serialized, data written before a failure is flushed and stays committed,
+// so the client can continue from the committed offset.
+func (store *DiskImageStore) AppendUploadSession(sessionID string, offset int64, data io.Reader) (*UploadSession, error) {
+	// lock session for writing
+	unlock := store.sessionLocks.Lock(sessionID)
+	defer unlock()
+
+	// trying to get session state
+	session, err := store.FindUploadSession(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// check that data continues committed data
+	if offset != session.Offset {
+		return nil, logError(status.Errorf(codes.FailedPrecondition, "offset %d doesn't match committed offset %d of upload session %s", offset, session.Offset, sessionID))
+	}
+
+	// trying to open staged data file for appending
+	file, err := os.OpenFile(stagingFilePath(store.imageFolder, sessionID), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open upload session file: %w", err)
+	}
+
+	// trying to write data chunk by chunk and flush it to disk (even if reading was interrupted)
+	size, err := io.Copy(file, data)
+	syncErr := file.Sync()
+	if closeErr := file.Close(); syncErr == nil {
+		syncErr = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot append data to upload session: %w", err)
+	}
+	if syncErr != nil {
+		return nil, fmt.Errorf("cannot flush upload session file: %w", syncErr)
+	}
+
+	session.Offset += size
+	session.UpdatedAt = time.Now()
+
+	return session, nil
+}
+
+// FindUploadSession returns a copy of upload session or not found error if there is no session with such identifier
+func (store *DiskImageStore) FindUploadSession(sessionID string) (*UploadSession, error) {
+	// trying to get session copy
+	store.mutex.RLock()
+	session := store.sessions[sessionID]
+	if session != nil {
+		session = copySession(session)
+	}
+	store.mutex.RUnlock()
+	if session == nil {
+		return nil, logError(status.Errorf(codes.NotFound, "upload session doesn't exists: %v", sessionID))
+	}
+
+	// get committed offset and time of the last append from staged data file (missing file means no data yet)
+	session.UpdatedAt = session.CreatedAt
+	fileInfo, err := os.Stat(stagingFilePath(store.imageFolder, sessionID))
+	if err == nil {
+		session.Offset = fileInfo.Size()
+		if fileInfo.ModTime().After(session.UpdatedAt) {
+			session.UpdatedAt = fileInfo.ModTime()
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("cannot stat upload session file: %w", err)
+	}
+
+	return session, nil
+}
+
+// OpenUploadSession returns a copy of upload session and a reader of data committed to it by the moment of the call.
+// The session is marked as being finalized until the reader is closed, so it can't be opened again meanwhile.
+func (store *DiskImageStore) OpenUploadSession(sessionID string) (*UploadSession, io.ReadCloser, error) {
+	// lock session for reading (waiting for running appends)
+	unlock := store.sessionLocks.RLock(sessionID)
+	defer unlock()
+
+	// trying to mark the session as being finalized
+	release, err := store.claimSession(sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// trying to get session state
+	session, err := store.FindUploadSession(sessionID)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+
+	// trying to open staged data file
+	file, err := os.Open(stagingFilePath(store.imageFolder, sessionID))
+	if errors.Is(err, os.ErrNotExist) {
+		return session, &sessionDataReader{Reader: strings.NewReader(""), close: func() error { release(); return nil }}, nil
+	}
+	if err != nil {
+		release()
+		return nil, nil, fmt.Errorf("cannot open upload session file: %w", err)
+	}
+
+	// limit reader with the committed offset so appends started later aren't read
+	closeFile := func() error {
+		defer release()
+		return file.Close()
+	}
+	return session, &sessionDataReader{Reader: io.LimitReader(file, session.Offset), close: closeFile}, nil
+}
+
+// claimSession marks the session as being finalized and returns the function unmarking it,
+// FailedPrecondition error is returned if the session is being finalized already
+func (store *DiskImageStore) claimSession(sessionID string) (func(), error) {
+	// lock in-memory storage
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	session := store.sessions[sessionID]
+	if session == nil {
+		return nil, logError(status.Errorf(codes.NotFound, "upload session doesn't exists: %v", sessionID))
+	}
+	if session.finalizing {
+		return nil, logError(status.Errorf(codes.FailedPrecondition, "upload session %s is being finalized already", sessionID))
+	}
+	session.finalizing = true
+
+	return func() {
+		store.mutex.Lock()
+		session.finalizing = false
+		store.mutex.Unlock()
+	}, nil
+}
+
+// sessionDataReader is a reader of committed data of the upload session releasing the session on close
+type sessionDataReader struct {
+	io.Reader
+	close func() error
+}
+
+// Close releases the session data
+func (reader *sessionDataReader) Close() error {
+	return reader.close()
+}
+
+// DeleteUploadSession removes upload session from the index and its staged data file
+func (store *DiskImageStore) DeleteUploadSession(sessionID string) error {
+	// lock session for writing
+	unlock := store.sessionLocks.Lock(sessionID)
+	defer unlock()
+
+	return store.deleteSession(sessionID)
+}
+
+// CollectUploadSessions removes upload sessions without appends since the time
+func (store *DiskImageStore) CollectUploadSessions(idleSince time.Time) (int, error) {
+	// take identifiers of all sessions
+	store.mutex.RLock()
+	sessionIDs := make([]string, 0, len(store.sessions))
+	for sessionID := range store.sessions {
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	store.mutex.RUnlock()
+
+	collected := 0
+	for _, sessionID := range sessionIDs {
+		// skip sessions which are in use (without waiting for running appends)
+		session, err := store.FindUploadSession(sessionID)
+		if err != nil || !session.UpdatedAt.Before(idleSince) {
+			continue
+		}
+
+		// trying to remove the session if it is still idle under the lock
+		removed, err := store.collectSession(sessionID, idleSince)
+		if err != nil {
+			return collected, err
+		}
+		if removed {
+			log.Printf("removed upload session %s idle since %v", sessionID, session.UpdatedAt)
+			collected++
+		}
+	}
+
+	return collected, nil
+}
+
+// collectSession removes upload session if it is idle since the time and reports if it was removed
+func (store *DiskImageStore) collectSession(sessionID string, idleSince time.Time) (bool, error) {
+	// lock session for writing
+	unlock := store.sessionLocks.Lock(sessionID)
+	defer unlock()
+
+	// check that session is still there and idle
+	session, err := store.FindUploadSession(sessionID)
+	if status.Code(err) == codes.NotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !session.UpdatedAt.Before(idleSince) || session.finalizing {
+		return false, nil
+	}
+
+	return true, store.deleteSession(sessionID)
+}
+
+// deleteSession removes upload session from the index and its staged data file (caller must hold the session lock)
+func (store *DiskImageStore) deleteSession(sessionID string) error {
+	// check that the session exists (it can't be removed by others while the session is locked)
+	store.mutex.RLock()
+	session := store.sessions[sessionID]
+	store.mutex.RUnlock()
+	if session == nil {
+		return logError(status.Errorf(codes.NotFound, "upload session doesn't exists: %v", sessionID))
+	}
+
+	// trying to remove the session and persist the index, restore the session on failure
+	err := store.commitIndex(func() {
+		delete(store.sessions, sessionID)
+	}, func() {
+		store.sessions[sessionID] = session
+	})
+	if err != nil {
+		return err
+	}
+
+	// trying to remove staged data file
+	stagingPath := stagingFilePath(store.imageFolder, sessionID)
+	err = os.Remove(stagingPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("cannot remove upload session file %s: %v", stagingPath, err)
+	}
+
+	return nil
+}
+
+// function to copy upload session with info of its image
+func copySession(session *UploadSession) *UploadSession {
+	sessionCopy := *session
+	imageInfoCopy := *session.ImageInfo
+	sessionCopy.ImageInfo = &imageInfoCopy
+	return &sessionCopy
+}
+
+// scanStagingFolder reconciles upload sessions with staged data files lying in the staging folder:
+// sessions with malformed identifiers are dropped and staged data files of unknown sessions are removed.
+// It returns true if upload sessions were changed.
+func scanStagingFolder(imageFolder string, sessions map[string]*UploadSession) (bool, error) {
+	// trying to create staging folder if it doesn't exist yet
+	stagingFolder := filepath.Join(imageFolder, stagingFolderName)
+	err := os.MkdirAll(stagingFolder, 0755)
+	if err != nil {
+		return false, fmt.Errorf("cannot create staging folder: %w", err)
+	}
+
+	changed := false
+
+	// drop sessions which can't be mapped to a staged data file safely
+	for sessionID := range sessions {
+		if !randomIDPattern.MatchString(sessionID) {
+			log.Printf("upload session id %q is malformed, removing it from the index", sessionID)
+			delete(sessions, sessionID)
+			changed = true
+		}
+	}
+
+	// trying to read staging folder entries
+	entries, err := os.ReadDir(stagingFolder)
+	if err != nil {
+		return false, fmt.Errorf("cannot read staging folder: %w", err)
+	}
+
+	// remove data of sessions unknown to the index
+	for _, entry := range entries {
+		if sessions[entry.Name()] == nil {
+			log.Printf("removing abandoned upload data %s", entry.Name())
+			os.RemoveAll(filepath.Join(stagingFolder, entry.Name()))
+		}
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,144 @@
+package service_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/MrPark97/tages/pb"
+	"github.com/MrPark97/tages/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClientUploadSession(t *testing.T) {
+	t.Parallel()
+
+	testImageFolder := t.TempDir()
+	imageStore, err := service.NewDiskImageStore(testImageFolder)
+	require.NoError(t, err)
+
+	serverAddress := startTestImageServer(t, imageStore)
+	imageClient := newTestImageClient(t, serverAddress)
+
+	imageData, err := os.ReadFile("../tmp/laptop.jpeg")
+	require.NoError(t, err)
+	imageChecksum := sha256.Sum256(imageData)
+
+	createRes, err := imageClient.CreateUploadSession(context.Background(), &pb.CreateUploadSessionRequest{
+		Info: &pb.Info{
+			Name:     "laptop",
+			Type:     ".jpeg",
+			Checksum: hex.EncodeToString(imageChecksum[:]),
+		},
+	})
+	require.NoError(t, err)
+	sessionID := createRes.GetSession().GetId()
+	require.NotEmpty(t, sessionID)
+	require.Zero(t, createRes.GetSession().GetCommittedOffset())
+
+	// the first half of data is committed
+	half := len(imageData) / 2
+	committedOffset, err := appendTestUploadSession(imageClient, sessionID, 0, imageData[:half])
+	require.NoError(t, err)
+	require.Equal(t, uint64(half), committedOffset)
+
+	// the session survives the store restart
+	imageStore, err = service.NewDiskImageStore(testImageFolder)
+	require.NoError(t, err)
+	serverAddress = startTestImageServer(t, imageStore)
+	imageClient = newTestImageClient(t, serverAddress)
+
+	getRes, err := imageClient.GetUploadSession(context.Background(), &pb.GetUploadSessionRequest{SessionId: sessionID})
+	require.NoError(t, err)
+	require.Equal(t, uint64(half), getRes.GetSession().GetCommittedOffset())
+	require.Equal(t, "laptop", getRes.GetSession().GetInfo().GetName())
+
+	// data not continuing committed data is rejected
+	_, err = appendTestUploadSession(imageClient, sessionID, 0, imageData)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// the rest of data is committed from the committed offset
+	committedOffset, err = appendTestUploadSession(imageClient, sessionID, uint64(half), imageData[half:])
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(imageData)), committedOffset)
+
+	res, err := imageClient.FinalizeUploadSession(context.Background(), &pb.FinalizeUploadSessionRequest{SessionId: sessionID})
+	require.NoError(t, err)
+	require.Equal(t, "laptop", res.GetName())
+	require.Equal(t, uint32(len(imageData)), res.GetSize())
+	require.Equal(t, hex.EncodeToString(imageChecksum[:]), res.GetChecksum())
+	require.Equal(t, imageData, downloadTestImage(t, imageStore, "laptop"))
+
+	// source modification time is unset since the client sent neither source_modified_at nor updated_at
+	infoRes, err := imageClient.GetImageInfo(context.Background(), &pb.GetImageInfoRequest{Name: "laptop"})
+	require.NoError(t, err)
+	require.Nil(t, infoRes.GetInfo().GetSourceModifiedAt())
+
+	// finalized session is removed, so a retried finalize doesn't save the image again
+	_, err = imageClient.GetUploadSession(context.Background(), &pb.GetUploadSessionRequest{SessionId: sessionID})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = imageClient.FinalizeUploadSession(context.Background(), &pb.FinalizeUploadSessionRequest{SessionId: sessionID})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	versions, err := imageStore.ListVersions("laptop")
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	entries, err := os.ReadDir(testImageFolder + "/.staging")
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestJanitorCollectsUploadSessions(t *testing.T) {
+	t.Parallel()
+
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	session, err := imageStore.CreateUploadSession(&service.ImageInfo{ImageName: "laptop", Type: ".jpeg"})
+	require.NoError(t, err)
+
+	janitor := service.NewJanitor(imageStore)
+	janitor.UploadSessionTTL = time.Hour
+
+	// the session isn't idle for long enough yet
+	janitor.Collect(time.Now())
+	_, err = imageStore.FindUploadSession(session.ID)
+	require.NoError(t, err)
+
+	janitor.Collect(time.Now().Add(2 * time.Hour))
+	_, err = imageStore.FindUploadSession(session.ID)
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// function to append data to the upload session in chunks of 1 kilobyte and return committed offset
+func appendTestUploadSession(imageClient pb.ImageServiceClient, sessionID string, offset uint64, data []byte) (uint64, error) {
+	stream, err := imageClient.AppendUploadSession(context.Background())
+	if err != nil {
+		return 0, err
+	}
+
+	for len(data) > 0 {
+		n := 1024
+		if n > len(data) {
+			n = len(data)
+		}
+
+		err = stream.Send(&pb.AppendUploadSessionRequest{SessionId: sessionID, Offset: offset, ChunkData: data[:n]})
+		if err != nil {
+			break
+		}
+		offset += uint64(n)
+		data = data[n:]
+	}
+
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return 0, err
+	}
+
+	return res.GetSession().GetCommittedOffset(), nil
+}
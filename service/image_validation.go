@@ -0,0 +1,101 @@
+package service
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/MrPark97/tages/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// maximum length of image name in bytes (leaves room for type and service prefixes within file name limits)
+const maxImageNameLength = 128
+
+// image name consists of letters, digits, dots, dashes and underscores and starts with a letter or a digit,
+// so it can't contain path separators, point to parent folder or be hidden
+var imageNamePattern = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N}._-]*$`)
+
+// checksum is hex encoded SHA-256
+var checksumPattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// allowed image types (extensions with dot, compared case insensitively)
+var imageFileExtensions = map[string]bool{
+	".jpeg": true,
+	".jpg":  true,
+	".png":  true,
+	".gif":  true,
+	".bmp":  true,
+	".webp": true,
+}
+
+// ValidateImageName checks that image name is safe to be used as a file name in the image folder
+// and returns InvalidArgument error with the reason otherwise
+func ValidateImageName(imageName string) error {
+	if imageName == "" {
+		return status.Error(codes.InvalidArgument, "invalid image name: name is empty")
+	}
+	if len(imageName) > maxImageNameLength {
+		return status.Errorf(codes.InvalidArgument, "invalid image name: name is longer than %d bytes", maxImageNameLength)
+	}
+	if !imageNamePattern.MatchString(imageName) {
+		return status.Errorf(codes.InvalidArgument, "invalid image name %q: only letters, digits, '.', '-' and '_' are allowed and the name must start with a letter or a digit", imageName)
+	}
+	if strings.HasSuffix(imageName, ".") {
+		return status.Errorf(codes.InvalidArgument, "invalid image name %q: name must not end with '.'", imageName)
+	}
+	return nil
+}
+
+// ValidateImageType checks that image type is one of the allowed image extensions
+// and returns InvalidArgument error with the reason otherwise
+func ValidateImageType(imageType string) error {
+	if !imageFileExtensions[strings.ToLower(imageType)] {
+		return status.Errorf(codes.InvalidArgument, "invalid image type %q: allowed types are .jpeg, .jpg, .png, .gif, .bmp and .webp", imageType)
+	}
+	return nil
+}
+
+// ValidateChecksum checks that checksum is empty or hex encoded SHA-256
+// and returns InvalidArgument error with the reason otherwise
+func ValidateChecksum(checksum string) error {
+	if checksum != "" && !checksumPattern.MatchString(checksum) {
+		return status.Errorf(codes.InvalidArgument, "invalid checksum %q: hex encoded SHA-256 expected", checksum)
+	}
+	return nil
+}
+
+// ValidateConflictPolicy checks that conflict policy is one of the known policies
+// and returns InvalidArgument error otherwise
+func ValidateConflictPolicy(conflictPolicy pb.ConflictPolicy) error {
+	if _, ok := pb.ConflictPolicy_name[int32(conflictPolicy)]; !ok {
+		return status.Errorf(codes.InvalidArgument, "unknown conflict policy: %d", conflictPolicy)
+	}
+	return nil
+}
+
+// ValidateExpectedVersion checks that expected version is set only with the overwrite conflict policy
+// (other policies never replace the existing image) and returns InvalidArgument error otherwise
+func ValidateExpectedVersion(expectedVersion uint64, conflictPolicy pb.ConflictPolicy) error {
+	if expectedVersion != 0 && conflictPolicy != pb.ConflictPolicy_CONFLICT_POLICY_OVERWRITE {
+		return status.Errorf(codes.InvalidArgument, "expected version can't be used with conflict policy %v", conflictPolicy)
+	}
+	return nil
+}
+
+// ValidateExpiry checks that at most one of expiration time and time to live is set
+// and the image doesn't expire right away, returns InvalidArgument error otherwise
+func ValidateExpiry(expiresAt *timestamppb.Timestamp, ttl *durationpb.Duration) error {
+	switch {
+	case expiresAt != nil && ttl != nil:
+		return status.Errorf(codes.InvalidArgument, "either expires_at or ttl can be set")
+	case expiresAt != nil && (expiresAt.CheckValid() != nil || !expiresAt.AsTime().After(time.Now())):
+		return status.Errorf(codes.InvalidArgument, "expires_at must be a valid time in the future")
+	case ttl != nil && (ttl.CheckValid() != nil || ttl.AsDuration() <= 0):
+		return status.Errorf(codes.InvalidArgument, "ttl must be a positive duration")
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package service_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MrPark97/tages/pb"
+	"github.com/MrPark97/tages/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestValidateImageName(t *testing.T) {
+	t.Parallel()
+
+	validNames := []string{
+		"laptop",
+		"MacBook-Pro_2023.v2",
+		"ноутбук",
+		strings.Repeat("a", 128),
+	}
+	for _, imageName := range validNames {
+		require.NoError(t, service.ValidateImageName(imageName), imageName)
+	}
+
+	hostileNames := []string{
+		"",
+		".",
+		"..",
+		"../../etc/cron.d/x",
+		"..\\..\\windows\\x",
+		"img/laptop",
+		"/etc/passwd",
+		".index",
+		"-rf",
+		"laptop ",
+		"lap top",
+		"laptop.",
+		"laptop\x00.png",
+		"laptop\n",
+		"%2e%2e",
+		strings.Repeat("a", 129),
+	}
+	for _, imageName := range hostileNames {
+		err := service.ValidateImageName(imageName)
+		require.Equal(t, codes.InvalidArgument, status.Code(err), imageName)
+	}
+}
+
+func TestValidateImageType(t *testing.T) {
+	t.Parallel()
+
+	for _, imageType := range []string{".jpeg", ".jpg", ".png", ".gif", ".bmp", ".webp", ".PNG"} {
+		require.NoError(t, service.ValidateImageType(imageType), imageType)
+	}
+
+	for _, imageType := range []string{"", ".", "png", ".exe", ".png/../../x", "/.png", ".jpeg.sh", ".png\x00"} {
+		err := service.ValidateImageType(imageType)
+		require.Equal(t, codes.InvalidArgument, status.Code(err), imageType)
+	}
+}
+
+func TestClientUploadImageWithHostileName(t *testing.T) {
+	t.Parallel()
+
+	rootFolder := t.TempDir()
+	testImageFolder := filepath.Join(rootFolder, "img")
+	imageStore, err := service.NewDiskImageStore(testImageFolder)
+	require.NoError(t, err)
+
+	serverAddress := startTestImageServer(t, imageStore)
+	imageClient := newTestImageClient(t, serverAddress)
+
+	for _, info := range []*pb.Info{
+		{Name: "../escaped", Type: ".jpeg"},
+		{Name: "laptop", Type: "/../../escaped.jpeg"},
+		{Name: "laptop", Type: ".sh"},
+	} {
+		stream, err := imageClient.UploadImage(context.Background())
+		require.NoError(t, err)
+
+		err = stream.Send(&pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_Info{Info: info},
+		})
+		require.NoError(t, err)
+
+		_, err = stream.CloseAndRecv()
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	}
+
+	// nothing is written outside (or inside) the image folder
+	entries, err := os.ReadDir(rootFolder)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Empty(t, imageStore.List(service.ListOptions{}))
+
+	// download and deletion by hostile name are rejected too
+	downloadStream, err := imageClient.DownloadImage(context.Background(), &pb.DownloadImageRequest{Name: "../img/.index"})
+	require.NoError(t, err)
+	_, err = downloadStream.Recv()
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = imageClient.DeleteImage(context.Background(), &pb.DeleteImageRequest{Name: "../escaped"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// name of the folder in image folder keeping files of previous versions of images
+const versionsFolderName = ".versions"
+
+// number of previous versions kept for every image by default
+const defaultVersionRetention = 5
+
+// WithVersionRetention sets number of previous versions kept for every image (zero disables history)
+func WithVersionRetention(count int) DiskImageStoreOption {
+	return func(store *DiskImageStore) {
+		if count < 0 {
+			count = 0
+		}
+		store.versionRetention = count
+	}
+}
+
+// function to get path of the folder with files of previous versions of the image
+func versionFolderPath(imageFolder string, imageName string) string {
+	return filepath.Join(imageFolder, versionsFolderName, imageName)
+}
+
+// function to get path of the file of the previous version of the image
+func versionFilePath(imageFolder string, imageName string, version int64, imageType string) string {
+	return filepath.Join(versionFolderPath(imageFolder, imageName), fmt.Sprintf("%d%s", version, imageType))
+}
+
+// ListVersions returns copies of info of the current and kept previous versions of the image from the newest one
+func (store *DiskImageStore) ListVersions(imageName string) ([]*ImageInfo, error) {
+	// lock in-memory storage for reading
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	// if no image with such name (or it is expired) return not found error
+	imageInfo := store.liveImage(imageName)
+	if imageInfo == nil {
+		return nil, logError(status.Errorf(codes.NotFound, "image doesn't exists: %v", imageName))
+	}
+
+	return newestVersions(imageInfo, store.versions[imageName]), nil
+}
+
+// newestVersions returns copies of info of the current and previous versions of the image from the newest one
+// (so callers can't race with the store)
+func newestVersions(imageInfo *ImageInfo, imageVersions []*ImageInfo) []*ImageInfo {
+	versions := make([]*ImageInfo, 0, len(imageVersions)+1)
+	imageInfoCopy := *imageInfo
+	versions = append(versions, &imageInfoCopy)
+	for i := len(imageVersions) - 1; i >= 0; i-- {
+		versionInfoCopy := *imageVersions[i]
+		versions = append(versions, &versionInfoCopy)
+	}
+
+	return versions
+}
+
+// appendVersion returns previous versions with the version appended (without modifying the given slice)
+// and versions out of the retention dropped from the oldest one
+func appendVersion(versions []*ImageInfo, versionInfo *ImageInfo, retention int) ([]*ImageInfo, []*ImageInfo) {
+	versions = append(versions[:len(versions):len(versions)], versionInfo)
+	if len(versions) <= retention {
+		return versions, nil
+	}
+	return versions[len(versions)-retention:], versions[:len(versions)-retention]
+}
+
+// findVersion returns a copy of info of the image version (zero means the current version) and path of its file
+func (store *DiskImageStore) findVersion(imageName string, version int64) (*ImageInfo, string, error) {
+	// lock in-memory storage for reading
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	// if no image with such name (or it is expired) return not found error
+	imageInfo := store.liveImage(imageName)
+	if imageInfo == nil {
+		return nil, "", logError(status.Errorf(codes.NotFound, "image doesn't exists: %v", imageName))
+	}
+
+	// check the current version
+	if version == 0 || version == imageInfo.Version {
+		imageInfoCopy := *imageInfo
+		return &imageInfoCopy, fmt.Sprintf("%s/%s%s", store.imageFolder, imageName, imageInfo.Type), nil
+	}
+
+	// look for the version in the history
+	for _, versionInfo := range store.versions[imageName] {
+		if versionInfo.Version == version {
+			versionInfoCopy := *versionInfo
+			return &versionInfoCopy, versionFilePath(store.imageFolder, imageName, version, versionInfo.Type), nil
+		}
+	}
+
+	return nil, "", logError(status.Errorf(codes.NotFound, "version %d of image %s doesn't exists", version, imageName))
+}
+
+// RestoreVersion saves content of the kept version as a new current version of the image,
+// so the replaced current version is kept in the history as well
+func (store *DiskImageStore) RestoreVersion(imageName string, version int64) (*ImageInfo, error) {
+	// trying to open the version (under the image read lock, so info and file match each other)
+	unlock := store.imageLocks.RLock(imageName)
+	versionInfo, file, err := store.openImage(imageName, version)
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// trying to save content of the version as a new version
+	imageInfo := restoredImageInfo(versionInfo)
+	_, err = store.Save(imageInfo, file)
+	if err != nil {
+		return nil, err
+	}
+
+	return imageInfo, nil
+}
+
+// restoredImageInfo returns info to save content of the version as a new version with properties of the restored one
+func restoredImageInfo(versionInfo *ImageInfo) *ImageInfo {
+	return &ImageInfo{
+		ImageName:        versionInfo.ImageName,
+		Type:             versionInfo.Type,
+		SourceModifiedAt: versionInfo.SourceModifiedAt,
+		Width:            versionInfo.Width,
+		Height:           versionInfo.Height,
+		ColorModel:       versionInfo.ColorModel,
+		Format:           versionInfo.Format,
+		Checksum:         versionInfo.Checksum,
+	}
+}
+
+// scanVersionsFolder reconciles previous versions with files lying in the versions folder:
+// history of unknown images and versions whose files are gone are dropped and files unknown to the index are removed.
+// It returns true if previous versions were changed.
+func scanVersionsFolder(imageFolder string, images map[string]*ImageInfo, versions map[string][]*ImageInfo) (bool, error) {
+	// trying to create versions folder if it doesn't exist yet
+	versionsFolder := filepath.Join(imageFolder, versionsFolderName)
+	err := os.MkdirAll(versionsFolder, 0755)
+	if err != nil {
+		return false, fmt.Errorf("cannot create versions folder: %w", err)
+	}
+
+	changed := false
+
+	// drop versions of unknown images and versions whose files are gone
+	for imageName, imageVersions := range versions {
+		imageInfo := images[imageName]
+		keptVersions := make([]*ImageInfo, 0, len(imageVersions))
+		for _, versionInfo := range imageVersions {
+			if versionInfo == nil || imageInfo == nil || versionInfo.Version >= imageInfo.Version {
+				continue
+			}
+			if _, err := os.Stat(versionFilePath(imageFolder, imageName, versionInfo.Version, versionInfo.Type)); err != nil {
+				continue
+			}
+			keptVersions = append(keptVersions, versionInfo)
+		}
+		if len(keptVersions) == len(imageVersions) {
+			continue
+		}
+
+		log.Printf("%d versions of image %s are missing, removing them from the index", len(imageVersions)-len(keptVersions), imageName)
+		if len(keptVersions) == 0 {
+			delete(versions, imageName)
+		} else {
+			versions[imageName] = keptVersions
+		}
+		changed = true
+	}
+
+	// trying to read versions folder entries
+	entries, err := os.ReadDir(versionsFolder)
+	if err != nil {
+		return false, fmt.Errorf("cannot read versions folder: %w", err)
+	}
+
+	// remove files of versions unknown to the index
+	for _, entry := range entries {
+		imageName := entry.Name()
+		versionFiles := make(map[string]bool)
+		for _, versionInfo := range versions[imageName] {
+			versionFiles[filepath.Base(versionFilePath(imageFolder, imageName, versionInfo.Version, versionInfo.Type))] = true
+		}
+		if len(versionFiles) == 0 {
+			log.Printf("removing abandoned versions of %s", imageName)
+			os.RemoveAll(filepath.Join(versionsFolder, imageName))
+			continue
+		}
+
+		files, err := os.ReadDir(filepath.Join(versionsFolder, imageName))
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if !versionFiles[file.Name()] {
+				log.Printf("removing abandoned version file %s of %s", file.Name(), imageName)
+				os.RemoveAll(filepath.Join(versionsFolder, imageName, file.Name()))
+			}
+		}
+	}
+
+	return changed, nil
+}
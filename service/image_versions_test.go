@@ -0,0 +1,123 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/MrPark97/tages/pb"
+	"github.com/MrPark97/tages/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDiskImageStoreKeepsVersions(t *testing.T) {
+	t.Parallel()
+
+	testImageFolder := t.TempDir()
+	imageStore, err := service.NewDiskImageStore(testImageFolder, service.WithVersionRetention(2))
+	require.NoError(t, err)
+
+	// save 4 versions of the image
+	imagesData := make([][]byte, 0, 4)
+	for i := 0; i < 4; i++ {
+		imageData := randomPNG(t, 8, 8)
+		imagesData = append(imagesData, imageData)
+
+		imageInfo := &service.ImageInfo{ImageName: "noise", Type: ".png"}
+		_, err = imageStore.Save(imageInfo, bytes.NewReader(imageData))
+		require.NoError(t, err)
+		require.EqualValues(t, i+1, imageInfo.Version)
+	}
+
+	// only 2 previous versions are kept
+	requireVersions(t, imageStore, "noise", 4, 3, 2)
+	require.Equal(t, imagesData[3], downloadTestImageVersion(t, imageStore, "noise", 0))
+	require.Equal(t, imagesData[1], downloadTestImageVersion(t, imageStore, "noise", 2))
+
+	err = imageStore.Send(newTestDownloadStream(), "noise", service.SendOptions{Version: 1}, func(chunkData []byte) error {
+		return nil
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// restored content becomes a new version
+	imageInfo, err := imageStore.RestoreVersion("noise", 2)
+	require.NoError(t, err)
+	require.EqualValues(t, 5, imageInfo.Version)
+	require.Equal(t, imagesData[1], downloadTestImageVersion(t, imageStore, "noise", 0))
+	requireVersions(t, imageStore, "noise", 5, 4, 3)
+
+	// history survives the store restart
+	imageStore, err = service.NewDiskImageStore(testImageFolder, service.WithVersionRetention(2))
+	require.NoError(t, err)
+	requireVersions(t, imageStore, "noise", 5, 4, 3)
+	require.Equal(t, imagesData[2], downloadTestImageVersion(t, imageStore, "noise", 3))
+
+	// history is removed with the image
+	err = imageStore.Delete("noise")
+	require.NoError(t, err)
+	require.NoDirExists(t, testImageFolder+"/.versions/noise")
+}
+
+func TestClientRestoreImageVersion(t *testing.T) {
+	t.Parallel()
+
+	imageStore, err := service.NewDiskImageStore(t.TempDir())
+	require.NoError(t, err)
+
+	serverAddress := startTestImageServer(t, imageStore)
+	imageClient := newTestImageClient(t, serverAddress)
+
+	firstImageData := randomPNG(t, 8, 8)
+	res, err := uploadTestImage(t, imageClient, &pb.Info{Name: "noise", Type: ".png"}, firstImageData)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, res.GetVersion())
+
+	res, err = uploadTestImage(t, imageClient, &pb.Info{Name: "noise", Type: ".png"}, randomPNG(t, 16, 16))
+	require.NoError(t, err)
+	require.EqualValues(t, 2, res.GetVersion())
+
+	listRes, err := imageClient.ListImageVersions(context.Background(), &pb.ListImageVersionsRequest{Name: "noise"})
+	require.NoError(t, err)
+	require.Len(t, listRes.GetVersions(), 2)
+	require.EqualValues(t, 2, listRes.GetVersions()[0].GetVersion())
+	require.EqualValues(t, 16, listRes.GetVersions()[0].GetWidth())
+	require.EqualValues(t, 1, listRes.GetVersions()[1].GetVersion())
+	require.EqualValues(t, 8, listRes.GetVersions()[1].GetWidth())
+
+	restoreRes, err := imageClient.RestoreImageVersion(context.Background(), &pb.RestoreImageVersionRequest{Name: "noise", Version: 1})
+	require.NoError(t, err)
+	require.EqualValues(t, 3, restoreRes.GetInfo().GetVersion())
+	require.EqualValues(t, 8, restoreRes.GetInfo().GetWidth())
+
+	info, imageData, err := downloadTestImageRange(imageClient, &pb.DownloadImageRequest{Name: "noise"})
+	require.NoError(t, err)
+	require.EqualValues(t, 3, info.GetVersion())
+	require.Equal(t, firstImageData, imageData)
+
+	_, err = imageClient.RestoreImageVersion(context.Background(), &pb.RestoreImageVersionRequest{Name: "noise", Version: 10})
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func requireVersions(t *testing.T, imageStore service.ImageStore, imageName string, versions ...int64) {
+	imageVersions, err := imageStore.ListVersions(imageName)
+	require.NoError(t, err)
+
+	actualVersions := make([]int64, 0, len(imageVersions))
+	for _, versionInfo := range imageVersions {
+		actualVersions = append(actualVersions, versionInfo.Version)
+	}
+	require.Equal(t, versions, actualVersions)
+}
+
+func downloadTestImageVersion(t *testing.T, imageStore service.ImageStore, imageName string, version int64) []byte {
+	imageData := bytes.Buffer{}
+	err := imageStore.Send(newTestDownloadStream(), imageName, service.SendOptions{Version: version}, func(chunkData []byte) error {
+		imageData.Write(chunkData)
+		return nil
+	})
+	require.NoError(t, err)
+
+	return imageData.Bytes()
+}
@@ -0,0 +1,588 @@
+// Package storetest provides the behavioral contract every ImageStore must satisfy.
+// A backend runs it from its own tests with a factory of empty stores:
+//
+//	func TestMyImageStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) service.ImageStore {
+//			return NewMyImageStore()
+//		})
+//	}
+//
+// Subtests run in parallel, each with a store of its own, so the suite is meant to be run with -race.
+package storetest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MrPark97/tages/pb"
+	"github.com/MrPark97/tages/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StoreFactory returns a new empty store for the test (resources of the store are released by the test cleanup)
+type StoreFactory func(t *testing.T) service.ImageStore
+
+// Run runs the behavioral contract of ImageStore against stores made by the factory
+func Run(t *testing.T, newStore StoreFactory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, imageStore service.ImageStore)
+	}{
+		{"SaveAndFind", testSaveAndFind},
+		{"Overwrite", testOverwrite},
+		{"ConflictPolicy", testConflictPolicy},
+		{"ExpectedVersion", testExpectedVersion},
+		{"Checksum", testChecksum},
+		{"UnknownName", testUnknownName},
+		{"SendRange", testSendRange},
+		{"StringLimit", testStringLimit},
+		{"List", testList},
+		{"Versions", testVersions},
+		{"Trash", testTrash},
+		{"Expiry", testExpiry},
+		{"Usage", testUsage},
+		{"UploadSession", testUploadSession},
+		{"ConcurrentAccess", testConcurrentAccess},
+	}
+
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			tc.test(t, newStore(t))
+		})
+	}
+}
+
+// function to make content of the size unique for the seed
+func content(seed int, size int) []byte {
+	data := bytes.Repeat([]byte(fmt.Sprintf("%08d", seed)), size/8+1)
+	return data[:size]
+}
+
+// function to get hex encoded SHA-256 of the content
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// function to save the content under the name and return info set by the store
+func save(t *testing.T, imageStore service.ImageStore, imageName string, data []byte) *service.ImageInfo {
+	imageInfo := &service.ImageInfo{ImageName: imageName, Type: ".png"}
+	savedName, err := imageStore.Save(imageInfo, bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, imageName, savedName)
+	return imageInfo
+}
+
+// downloadStream is a DownloadImage stream which only collects sent image info
+type downloadStream struct {
+	grpc.ServerStream
+	infos []*pb.Info
+}
+
+func (stream *downloadStream) Context() context.Context {
+	return context.Background()
+}
+
+func (stream *downloadStream) Send(res *pb.DownloadImageResponse) error {
+	stream.infos = append(stream.infos, res.GetInfo())
+	return nil
+}
+
+// function to download the range of the image and return the sent info with the data
+func download(imageStore service.ImageStore, imageName string, options service.SendOptions) (*pb.Info, []byte, error) {
+	stream := &downloadStream{}
+	data := bytes.Buffer{}
+	err := imageStore.Send(stream, imageName, options, func(chunkData []byte) error {
+		data.Write(chunkData)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(stream.infos) == 0 {
+		return nil, nil, fmt.Errorf("image info isn't sent")
+	}
+
+	return stream.infos[0], data.Bytes(), nil
+}
+
+// function to download the whole current version of the image
+func requireContent(t *testing.T, imageStore service.ImageStore, imageName string, expected []byte) {
+	_, data, err := download(imageStore, imageName, service.SendOptions{})
+	require.NoError(t, err)
+	require.Equal(t, expected, data)
+}
+
+func testSaveAndFind(t *testing.T, imageStore service.ImageStore) {
+	data := content(1, 3000)
+	before := time.Now()
+	imageInfo := save(t, imageStore, "laptop", data)
+
+	// fields set by the store are reported back
+	require.Equal(t, int64(len(data)), imageInfo.Size)
+	require.Equal(t, checksum(data), imageInfo.Checksum)
+	require.Equal(t, int64(1), imageInfo.Version)
+	require.False(t, imageInfo.UpdatedAt.Before(before))
+	require.Equal(t, imageInfo.UpdatedAt, imageInfo.CreatedAt)
+
+	foundInfo, err := imageStore.Find("laptop")
+	require.NoError(t, err)
+	require.Equal(t, "laptop", foundInfo.ImageName)
+	require.Equal(t, ".png", foundInfo.Type)
+	require.Equal(t, imageInfo.Checksum, foundInfo.Checksum)
+	require.Equal(t, imageInfo.Version, foundInfo.Version)
+
+	// found info is a copy
+	foundInfo.Size = 0
+	foundInfo, err = imageStore.Find("laptop")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), foundInfo.Size)
+
+	requireContent(t, imageStore, "laptop", data)
+
+	// times set by the caller are ignored, both are stamped by the store
+	before = time.Now()
+	updatedAt := time.Date(2022, 12, 1, 10, 0, 0, 0, time.UTC)
+	_, err = imageStore.Save(&service.ImageInfo{ImageName: "notebook", Type: ".png", CreatedAt: updatedAt, UpdatedAt: updatedAt}, bytes.NewReader(data))
+	require.NoError(t, err)
+	foundInfo, err = imageStore.Find("notebook")
+	require.NoError(t, err)
+	require.False(t, foundInfo.UpdatedAt.Before(before))
+	require.Equal(t, foundInfo.UpdatedAt, foundInfo.CreatedAt)
+}
+
+func testOverwrite(t *testing.T, imageStore service.ImageStore) {
+	firstInfo := save(t, imageStore, "laptop", content(1, 2000))
+	time.Sleep(10 * time.Millisecond)
+
+	// overwrite replaces content and keeps creation time of the image
+	data := content(2, 1000)
+	secondInfo := save(t, imageStore, "laptop", data)
+	require.Equal(t, int64(2), secondInfo.Version)
+	require.True(t, firstInfo.CreatedAt.Equal(secondInfo.CreatedAt))
+	require.True(t, secondInfo.UpdatedAt.After(firstInfo.UpdatedAt))
+	require.Equal(t, int64(len(data)), secondInfo.Size)
+
+	foundInfo, err := imageStore.Find("laptop")
+	require.NoError(t, err)
+	require.True(t, firstInfo.CreatedAt.Equal(foundInfo.CreatedAt))
+	require.Equal(t, int64(2), foundInfo.Version)
+	requireContent(t, imageStore, "laptop", data)
+
+	// the image of another type replaces the image with the name
+	_, err = imageStore.Save(&service.ImageInfo{ImageName: "laptop", Type: ".jpeg"}, bytes.NewReader(data))
+	require.NoError(t, err)
+	foundInfo, err = imageStore.Find("laptop")
+	require.NoError(t, err)
+	require.Equal(t, ".jpeg", foundInfo.Type)
+	require.Len(t, imageStore.List(service.ListOptions{}), 1)
+}
+
+func testConflictPolicy(t *testing.T, imageStore service.ImageStore) {
+	save(t, imageStore, "laptop", content(1, 100))
+
+	_, err := imageStore.Save(&service.ImageInfo{ImageName: "laptop", Type: ".png", ConflictPolicy: pb.ConflictPolicy_CONFLICT_POLICY_FAIL_IF_EXISTS}, bytes.NewReader(content(2, 100)))
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+	requireContent(t, imageStore, "laptop", content(1, 100))
+
+	for i := 1; i <= 2; i++ {
+		imageInfo := &service.ImageInfo{ImageName: "laptop", Type: ".png", ConflictPolicy: pb.ConflictPolicy_CONFLICT_POLICY_AUTO_RENAME}
+		imageName, err := imageStore.Save(imageInfo, bytes.NewReader(content(2, 100)))
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("laptop-%d", i), imageName)
+		require.Equal(t, imageName, imageInfo.ImageName)
+		require.Equal(t, int64(1), imageInfo.Version)
+	}
+	requireContent(t, imageStore, "laptop", content(1, 100))
+	requireContent(t, imageStore, "laptop-2", content(2, 100))
+}
+
+func testExpectedVersion(t *testing.T, imageStore service.ImageStore) {
+	_, err := imageStore.Save(&service.ImageInfo{ImageName: "laptop", Type: ".png", ExpectedVersion: 1}, bytes.NewReader(content(1, 100)))
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	save(t, imageStore, "laptop", content(1, 100))
+	_, err = imageStore.Save(&service.ImageInfo{ImageName: "laptop", Type: ".png", ExpectedVersion: 2}, bytes.NewReader(content(2, 100)))
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	imageInfo := &service.ImageInfo{ImageName: "laptop", Type: ".png", ExpectedVersion: 1}
+	_, err = imageStore.Save(imageInfo, bytes.NewReader(content(2, 100)))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), imageInfo.Version)
+	require.Zero(t, imageInfo.ExpectedVersion)
+}
+
+func testChecksum(t *testing.T, imageStore service.ImageStore) {
+	data := content(1, 100)
+
+	_, err := imageStore.Save(&service.ImageInfo{ImageName: "laptop", Type: ".png", Checksum: checksum(content(2, 100))}, bytes.NewReader(data))
+	require.Equal(t, codes.DataLoss, status.Code(err))
+	_, err = imageStore.Find("laptop")
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = imageStore.Save(&service.ImageInfo{ImageName: "laptop", Type: ".png", Checksum: strings.ToUpper(checksum(data))}, bytes.NewReader(data))
+	require.NoError(t, err)
+
+	// download of a changed image is rejected
+	_, _, err = download(imageStore, "laptop", service.SendOptions{ExpectedChecksum: checksum(content(2, 100))})
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// only info is sent if the client copy is current
+	info, received, err := download(imageStore, "laptop", service.SendOptions{IfNoneMatch: checksum(data)})
+	require.NoError(t, err)
+	require.True(t, info.GetNotModified())
+	require.Empty(t, received)
+}
+
+func testUnknownName(t *testing.T, imageStore service.ImageStore) {
+	_, err := imageStore.Find("laptop")
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, _, err = download(imageStore, "laptop", service.SendOptions{})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	err = imageStore.Delete("laptop")
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = imageStore.ListVersions("laptop")
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = imageStore.RestoreVersion("laptop", 1)
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = imageStore.RestoreTrashItem("00000000000000000000000000000000")
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = imageStore.FindUploadSession("00000000000000000000000000000000")
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, _, err = imageStore.OpenUploadSession("00000000000000000000000000000000")
+	require.Equal(t, codes.NotFound, status.Code(err))
+	err = imageStore.DeleteUploadSession("00000000000000000000000000000000")
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// unknown versions of an existing image aren't found either
+	save(t, imageStore, "laptop", content(1, 100))
+	_, _, err = download(imageStore, "laptop", service.SendOptions{Version: 7})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	_, err = imageStore.RestoreVersion("laptop", 7)
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func testSendRange(t *testing.T, imageStore service.ImageStore) {
+	data := content(1, 5000)
+	save(t, imageStore, "laptop", data)
+
+	info, received, err := download(imageStore, "laptop", service.SendOptions{Offset: 1000, Length: 2500})
+	require.NoError(t, err)
+	require.EqualValues(t, len(data), info.GetSize())
+	require.Equal(t, data[1000:3500], received)
+
+	_, received, err = download(imageStore, "laptop", service.SendOptions{Offset: 4000})
+	require.NoError(t, err)
+	require.Equal(t, data[4000:], received)
+
+	_, received, err = download(imageStore, "laptop", service.SendOptions{Offset: int64(len(data))})
+	require.NoError(t, err)
+	require.Empty(t, received)
+
+	_, _, err = download(imageStore, "laptop", service.SendOptions{Offset: int64(len(data)) + 1})
+	require.Equal(t, codes.OutOfRange, status.Code(err))
+}
+
+func testStringLimit(t *testing.T, imageStore service.ImageStore) {
+	// an empty store has only the header and the usage line
+	require.Len(t, strings.Split(strings.TrimSuffix(imageStore.String(0, pb.SortField_SORT_FIELD_NAME, pb.SortOrder_SORT_ORDER_ASC), "\n"), "\n"), 2)
+
+	for i, imageName := range []string{"b", "c", "a"} {
+		save(t, imageStore, imageName, content(i, 100*(i+1)))
+	}
+
+	for _, tc := range []struct {
+		limit uint32
+		names []string
+	}{
+		{0, []string{"a", "b", "c"}},
+		{2, []string{"a", "b"}},
+		{1, []string{"a"}},
+		{10, []string{"a", "b", "c"}},
+	} {
+		lines := strings.Split(strings.TrimSuffix(imageStore.String(tc.limit, pb.SortField_SORT_FIELD_NAME, pb.SortOrder_SORT_ORDER_ASC), "\n"), "\n")
+		require.Len(t, lines, len(tc.names)+2)
+		for i, imageName := range tc.names {
+			require.True(t, strings.HasPrefix(lines[i+1], imageName+".png "), "limit %d, line %q", tc.limit, lines[i+1])
+		}
+	}
+
+	// the limit is applied after sorting
+	lines := strings.Split(imageStore.String(1, pb.SortField_SORT_FIELD_SIZE, pb.SortOrder_SORT_ORDER_DESC), "\n")
+	require.True(t, strings.HasPrefix(lines[1], "a.png "))
+}
+
+func testList(t *testing.T, imageStore service.ImageStore) {
+	save(t, imageStore, "laptop", content(1, 300))
+	save(t, imageStore, "notebook", content(2, 100))
+	_, err := imageStore.Save(&service.ImageInfo{ImageName: "laptop-photo", Type: ".jpeg"}, bytes.NewReader(content(3, 200)))
+	require.NoError(t, err)
+
+	images := imageStore.List(service.ListOptions{SortField: pb.SortField_SORT_FIELD_SIZE})
+	require.Len(t, images, 3)
+	require.Equal(t, []string{"notebook", "laptop-photo", "laptop"}, []string{images[0].ImageName, images[1].ImageName, images[2].ImageName})
+
+	images = imageStore.List(service.ListOptions{NamePrefix: "laptop", Type: ".PNG"})
+	require.Len(t, images, 1)
+	require.Equal(t, "laptop", images[0].ImageName)
+
+	// listed info is a copy
+	images[0].Size = 0
+	imageInfo, err := imageStore.Find("laptop")
+	require.NoError(t, err)
+	require.Equal(t, int64(300), imageInfo.Size)
+}
+
+func testVersions(t *testing.T, imageStore service.ImageStore) {
+	for i := 1; i <= 3; i++ {
+		save(t, imageStore, "laptop", content(i, 100))
+	}
+
+	versions, err := imageStore.ListVersions("laptop")
+	require.NoError(t, err)
+	require.NotEmpty(t, versions)
+	require.Equal(t, int64(3), versions[0].Version)
+	if len(versions) == 1 {
+		// the store keeps no history
+		return
+	}
+	require.Equal(t, int64(2), versions[1].Version)
+
+	_, received, err := download(imageStore, "laptop", service.SendOptions{Version: 2})
+	require.NoError(t, err)
+	require.Equal(t, content(2, 100), received)
+
+	imageInfo, err := imageStore.RestoreVersion("laptop", 2)
+	require.NoError(t, err)
+	require.Equal(t, int64(4), imageInfo.Version)
+	require.Equal(t, checksum(content(2, 100)), imageInfo.Checksum)
+	requireContent(t, imageStore, "laptop", content(2, 100))
+}
+
+func testTrash(t *testing.T, imageStore service.ImageStore) {
+	save(t, imageStore, "laptop", content(1, 100))
+	save(t, imageStore, "laptop", content(2, 100))
+
+	err := imageStore.Delete("laptop")
+	require.NoError(t, err)
+	_, err = imageStore.Find("laptop")
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	items := imageStore.ListTrash()
+	require.Len(t, items, 1)
+	require.Equal(t, "laptop", items[0].ImageInfo.ImageName)
+
+	// the name is taken by a new image, so the deleted one can't be restored over it
+	save(t, imageStore, "laptop", content(3, 100))
+	_, err = imageStore.RestoreTrashItem(items[0].ID)
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	err = imageStore.Delete("laptop")
+	require.NoError(t, err)
+	require.Len(t, imageStore.ListTrash(), 2)
+
+	imageInfo, err := imageStore.RestoreTrashItem(items[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), imageInfo.Version)
+	requireContent(t, imageStore, "laptop", content(2, 100))
+	require.Len(t, imageStore.ListTrash(), 1)
+
+	purged, err := imageStore.PurgeTrash(time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Zero(t, purged)
+	purged, err = imageStore.PurgeTrash(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, purged)
+	require.Empty(t, imageStore.ListTrash())
+}
+
+func testExpiry(t *testing.T, imageStore service.ImageStore) {
+	_, err := imageStore.Save(&service.ImageInfo{ImageName: "laptop", Type: ".png", ExpiresAt: time.Now().Add(time.Hour)}, bytes.NewReader(content(1, 100)))
+	require.NoError(t, err)
+	save(t, imageStore, "notebook", content(2, 100))
+
+	evicted, err := imageStore.EvictExpired(time.Now())
+	require.NoError(t, err)
+	require.Zero(t, evicted)
+	_, err = imageStore.Find("laptop")
+	require.NoError(t, err)
+
+	// time to live is counted from the moment the image is committed
+	ttlInfo := &service.ImageInfo{ImageName: "draft", Type: ".png", TimeToLive: 3 * time.Hour}
+	_, err = imageStore.Save(ttlInfo, bytes.NewReader(content(3, 100)))
+	require.NoError(t, err)
+	require.True(t, ttlInfo.UpdatedAt.Add(3*time.Hour).Equal(ttlInfo.ExpiresAt))
+	require.Zero(t, ttlInfo.TimeToLive)
+	require.NoError(t, imageStore.Delete("draft"))
+
+	evicted, err = imageStore.EvictExpired(time.Now().Add(2 * time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, evicted)
+	_, err = imageStore.Find("laptop")
+	require.Equal(t, codes.NotFound, status.Code(err))
+	require.Len(t, imageStore.List(service.ListOptions{}), 1)
+
+	// an expired image which isn't evicted yet is treated as missing
+	var expiredInfo *service.ImageInfo
+	for i := 1; i <= 2; i++ {
+		expiredInfo = &service.ImageInfo{ImageName: "preview", Type: ".png", ExpiresAt: time.Now().Add(50 * time.Millisecond)}
+		_, err = imageStore.Save(expiredInfo, bytes.NewReader(content(i, 100)))
+		require.NoError(t, err)
+	}
+	err = imageStore.Delete("notebook")
+	require.NoError(t, err)
+	_, err = imageStore.Save(&service.ImageInfo{ImageName: "notebook", Type: ".png", ExpiresAt: time.Now().Add(50 * time.Millisecond)}, bytes.NewReader(content(3, 100)))
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	err = imageStore.Delete("preview")
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	// a new image with the name starts a new history
+	imageInfo := save(t, imageStore, "preview", content(4, 100))
+	require.Equal(t, int64(1), imageInfo.Version)
+	require.True(t, imageInfo.CreatedAt.After(expiredInfo.CreatedAt))
+	versions, err := imageStore.ListVersions("preview")
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	_, received, err := download(imageStore, "preview", service.SendOptions{Version: 1})
+	require.NoError(t, err)
+	require.Equal(t, content(4, 100), received)
+
+	// a deleted image is restored over the expired image with its name
+	restoredInfo, err := imageStore.RestoreTrashItem(imageStore.ListTrash()[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, "notebook", restoredInfo.ImageName)
+	requireContent(t, imageStore, "notebook", content(2, 100))
+}
+
+func testUsage(t *testing.T, imageStore service.ImageStore) {
+	require.Equal(t, service.StorageUsage{}, imageStore.Usage())
+
+	data := content(1, 1000)
+	save(t, imageStore, "laptop", data)
+	save(t, imageStore, "laptop-copy", data)
+
+	usage := imageStore.Usage()
+	require.Equal(t, int64(2*len(data)), usage.Logical)
+	require.LessOrEqual(t, usage.Physical, usage.Logical)
+	require.Positive(t, usage.Physical)
+}
+
+func testUploadSession(t *testing.T, imageStore service.ImageStore) {
+	data := content(1, 5000)
+	session, err := imageStore.CreateUploadSession(&service.ImageInfo{ImageName: "laptop", Type: ".png", Checksum: checksum(data)})
+	require.NoError(t, err)
+	require.NotEmpty(t, session.ID)
+	require.Zero(t, session.Offset)
+	require.Equal(t, "laptop", session.ImageInfo.ImageName)
+
+	session, err = imageStore.AppendUploadSession(session.ID, 0, bytes.NewReader(data[:2000]))
+	require.NoError(t, err)
+	require.Equal(t, int64(2000), session.Offset)
+
+	// data not continuing committed data is rejected
+	_, err = imageStore.AppendUploadSession(session.ID, 0, bytes.NewReader(data))
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	session, err = imageStore.AppendUploadSession(session.ID, 2000, bytes.NewReader(data[2000:]))
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), session.Offset)
+
+	foundSession, err := imageStore.FindUploadSession(session.ID)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), foundSession.Offset)
+	require.Equal(t, checksum(data), foundSession.ImageInfo.Checksum)
+
+	openedSession, reader, err := imageStore.OpenUploadSession(session.ID)
+	require.NoError(t, err)
+	committedData, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data)), openedSession.Offset)
+	require.Equal(t, data, committedData)
+
+	// the session can't be opened again until its data is closed, so it is finalized once
+	_, _, err = imageStore.OpenUploadSession(session.ID)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+	require.NoError(t, reader.Close())
+	_, reader, err = imageStore.OpenUploadSession(session.ID)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+
+	// idle sessions are collected
+	collected, err := imageStore.CollectUploadSessions(time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	require.Zero(t, collected)
+	idleSession, err := imageStore.CreateUploadSession(&service.ImageInfo{ImageName: "notebook", Type: ".png"})
+	require.NoError(t, err)
+
+	err = imageStore.DeleteUploadSession(session.ID)
+	require.NoError(t, err)
+	_, err = imageStore.FindUploadSession(session.ID)
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	collected, err = imageStore.CollectUploadSessions(time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, collected)
+	_, err = imageStore.FindUploadSession(idleSession.ID)
+	require.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func testConcurrentAccess(t *testing.T, imageStore service.ImageStore) {
+	imageData := content(1, 4000)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		imageName := []string{"laptop", "notebook"}[i%2]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// failures are reported with assert, since require can't stop the test from another goroutine
+			for j := 0; j < 10; j++ {
+				_, err := imageStore.Save(&service.ImageInfo{ImageName: imageName, Type: ".png"}, bytes.NewReader(imageData))
+				assert.NoError(t, err)
+
+				// download may only fail with not found if another goroutine has just deleted the image
+				_, data, err := download(imageStore, imageName, service.SendOptions{})
+				if err == nil {
+					assert.Equal(t, imageData, data)
+				} else {
+					assert.Equal(t, codes.NotFound, status.Code(err))
+				}
+
+				imageStore.Find(imageName)
+				imageStore.List(service.ListOptions{})
+				imageStore.ListVersions(imageName)
+				imageStore.String(1, pb.SortField_SORT_FIELD_SIZE, pb.SortOrder_SORT_ORDER_DESC)
+				imageStore.Usage()
+				imageStore.Delete(imageName)
+				imageStore.ListTrash()
+			}
+		}()
+	}
+	wg.Wait()
+	require.False(t, t.Failed())
+
+	// every goroutine deleted what it saved, so once the trash is purged only new content is stored
+	imageStore.PurgeTrash(time.Now().Add(time.Hour))
+	for _, imageName := range []string{"laptop", "notebook"} {
+		save(t, imageStore, imageName, imageData)
+		requireContent(t, imageStore, imageName, imageData)
+	}
+	require.Equal(t, service.StorageUsage{Logical: int64(2 * len(imageData)), Physical: int64(len(imageData))}, imageStore.Usage())
+}